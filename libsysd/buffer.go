@@ -0,0 +1,189 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"context"
+	"sync"
+)
+
+const defaultMetricsBufferLimit = 1024
+
+// OverflowPolicy decides what happens to a new event when the events buffer is full
+type OverflowPolicy int
+
+const (
+	// OverflowBlock waits until the consumer makes room in the buffer
+	OverflowBlock OverflowPolicy = iota
+	// OverflowDropOldest discards the oldest buffered event to make room for the new one
+	OverflowDropOldest
+	// OverflowDropNewest discards the new event
+	OverflowDropNewest
+	// OverflowCoalesce merges the new event into the buffered event of the same unit.
+	// If there is no buffered event for the unit, the oldest buffered event is discarded
+	OverflowCoalesce
+)
+
+// Stats contains the events buffer counters of a watcher
+type Stats struct {
+	Buffered  int    // Buffered number of events waiting to be consumed
+	Dropped   uint64 // Dropped number of events discarded because the buffer was full, or by go-systemd while Sub was behind
+	Coalesced uint64 // Coalesced number of events merged into a buffered event of the same unit
+}
+
+// eventQueue is a bounded single producer, single consumer queue of systemd events.
+// The limit is a cap, the events slice only grows with the events waiting to be consumed
+type eventQueue struct {
+	mutex     sync.Mutex
+	events    []*SystemDEvent
+	limit     int
+	policy    OverflowPolicy
+	closed    bool
+	dropped   uint64
+	coalesced uint64
+	notEmpty  chan struct{}
+	notFull   chan struct{}
+}
+
+func newEventQueue(limit int64, policy OverflowPolicy) *eventQueue {
+	if limit < 1 {
+		limit = defaultMetricsBufferLimit
+	}
+	return &eventQueue{
+		limit:    int(limit),
+		policy:   policy,
+		notEmpty: make(chan struct{}, 1),
+		notFull:  make(chan struct{}, 1),
+	}
+}
+
+// push adds an event to the queue applying the overflow policy when the queue is full.
+// It returns false only if the context is done while waiting for room in the queue
+func (q *eventQueue) push(ctx context.Context, e *SystemDEvent) bool {
+	for {
+		q.mutex.Lock()
+		if len(q.events) < q.limit {
+			q.events = append(q.events, e)
+			q.mutex.Unlock()
+			notify(q.notEmpty)
+			return true
+		}
+		switch q.policy {
+		case OverflowDropNewest:
+			q.dropped++
+			q.mutex.Unlock()
+			return true
+		case OverflowCoalesce:
+			if buffered := q.findUnit(e.UnitName); buffered != nil {
+				for p, v := range e.PropertyUpdate {
+					buffered.PropertyUpdate[p] = v
+				}
+				buffered.Timestamp = e.Timestamp
+				buffered.Hostname = e.Hostname
+				q.coalesced++
+				q.mutex.Unlock()
+				return true
+			}
+			q.dropOldest(e)
+			q.mutex.Unlock()
+			return true
+		case OverflowDropOldest:
+			q.dropOldest(e)
+			q.mutex.Unlock()
+			return true
+		default:
+			q.mutex.Unlock()
+			select {
+			case <-q.notFull:
+			case <-ctx.Done():
+				return false
+			}
+		}
+	}
+}
+
+// pop removes the oldest event from the queue, waiting for one if the queue is empty.
+// It returns false if the context is done or the queue is closed and drained
+func (q *eventQueue) pop(ctx context.Context) (*SystemDEvent, bool) {
+	for {
+		q.mutex.Lock()
+		if len(q.events) > 0 {
+			e := q.events[0]
+			q.events[0] = nil
+			q.events = q.events[1:]
+			q.mutex.Unlock()
+			notify(q.notFull)
+			return e, true
+		}
+		closed := q.closed
+		q.mutex.Unlock()
+		if closed {
+			return nil, false
+		}
+		select {
+		case <-q.notEmpty:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
+
+// close marks that no more events will be pushed, pop drains the remaining events
+func (q *eventQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	notify(q.notEmpty)
+}
+
+func (q *eventQueue) stats() Stats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return Stats{
+		Buffered:  len(q.events),
+		Dropped:   q.dropped,
+		Coalesced: q.coalesced,
+	}
+}
+
+// drop counts an event discarded before reaching the queue
+func (q *eventQueue) drop() {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.dropped++
+}
+
+func (q *eventQueue) dropOldest(e *SystemDEvent) {
+	q.events[0] = nil
+	q.events = append(q.events[1:], e)
+	q.dropped++
+}
+
+func (q *eventQueue) findUnit(unitName string) *SystemDEvent {
+	for _, buffered := range q.events {
+		if buffered.UnitName == unitName && buffered.PropertyUpdate != nil {
+			return buffered
+		}
+	}
+	return nil
+}
+
+// notify wakes up a waiter without blocking, the signal is kept if nobody is waiting yet
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
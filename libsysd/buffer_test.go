@@ -0,0 +1,127 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEventQueueOverflow(t *testing.T) {
+	events := []*SystemDEvent{
+		{UnitName: "a.service", PropertyUpdate: map[string]interface{}{"ActiveState": "activating"}},
+		{UnitName: "b.service", PropertyUpdate: map[string]interface{}{"ActiveState": "active"}},
+		{UnitName: "a.service", PropertyUpdate: map[string]interface{}{"ActiveState": "active"}},
+		{UnitName: "c.service", PropertyUpdate: map[string]interface{}{"ActiveState": "failed"}},
+	}
+	tests := []struct {
+		name      string
+		policy    OverflowPolicy
+		wantUnits []string
+		wantState []string
+		wantStats Stats
+	}{
+		{
+			name:      "drop newest",
+			policy:    OverflowDropNewest,
+			wantUnits: []string{"a.service", "b.service"},
+			wantState: []string{"activating", "active"},
+			wantStats: Stats{Buffered: 2, Dropped: 2},
+		},
+		{
+			name:      "drop oldest",
+			policy:    OverflowDropOldest,
+			wantUnits: []string{"a.service", "c.service"},
+			wantState: []string{"active", "failed"},
+			wantStats: Stats{Buffered: 2, Dropped: 2},
+		},
+		{
+			name:      "coalesce",
+			policy:    OverflowCoalesce,
+			wantUnits: []string{"b.service", "c.service"},
+			wantState: []string{"active", "failed"},
+			wantStats: Stats{Buffered: 2, Dropped: 1, Coalesced: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newEventQueue(2, tt.policy)
+			for _, e := range events {
+				copied := *e
+				copied.PropertyUpdate = map[string]interface{}{}
+				for p, v := range e.PropertyUpdate {
+					copied.PropertyUpdate[p] = v
+				}
+				if !q.push(context.Background(), &copied) {
+					t.Fatalf("push() returned false")
+				}
+			}
+			if got := q.stats(); got != tt.wantStats {
+				t.Errorf("stats() got = %+v, want %+v", got, tt.wantStats)
+			}
+			q.close()
+			var gotUnits, gotState []string
+			for {
+				e, ok := q.pop(context.Background())
+				if !ok {
+					break
+				}
+				gotUnits = append(gotUnits, e.UnitName)
+				gotState = append(gotState, e.PropertyUpdate["ActiveState"].(string))
+			}
+			if !reflect.DeepEqual(gotUnits, tt.wantUnits) || !reflect.DeepEqual(gotState, tt.wantState) {
+				t.Errorf("pop() got = %v %v, want %v %v", gotUnits, gotState, tt.wantUnits, tt.wantState)
+			}
+		})
+	}
+}
+
+func TestEventQueueLazy(t *testing.T) {
+	q := newEventQueue(1<<30, OverflowBlock)
+	if cap(q.events) != 0 {
+		t.Errorf("newEventQueue() allocated %d events up front, want none", cap(q.events))
+	}
+	q.push(context.Background(), &SystemDEvent{UnitName: "a.service"})
+	if cap(q.events) > 8 {
+		t.Errorf("push() grew the buffer to %d events for a single one", cap(q.events))
+	}
+}
+
+func TestEventQueueBlock(t *testing.T) {
+	q := newEventQueue(1, OverflowBlock)
+	q.push(context.Background(), &SystemDEvent{UnitName: "a.service"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if q.push(ctx, &SystemDEvent{UnitName: "b.service"}) {
+		t.Fatalf("push() on a full queue should block until the context is done")
+	}
+
+	pushed := make(chan bool)
+	go func() {
+		pushed <- q.push(context.Background(), &SystemDEvent{UnitName: "c.service"})
+	}()
+	if e, _ := q.pop(context.Background()); e.UnitName != "a.service" {
+		t.Errorf("pop() got = %s, want a.service", e.UnitName)
+	}
+	if !<-pushed {
+		t.Fatalf("push() should succeed once there is room in the queue")
+	}
+	if e, _ := q.pop(context.Background()); e.UnitName != "c.service" {
+		t.Errorf("pop() got = %s, want c.service", e.UnitName)
+	}
+}
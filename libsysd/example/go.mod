@@ -3,14 +3,14 @@ module sysd
 go 1.19
 
 require (
-	github.com/acceldata-io/goutils/libsysd v0.0.0-20221129134101-ed42388f3e5f
+	github.com/acceldata-io/goutils/libsysd v0.1.0
 	github.com/integrii/flaggy v1.5.2
 )
 
 require (
 	github.com/Showmax/go-fqdn v1.0.0 // indirect
-	github.com/acceldata-io/goutils/netutils v0.0.0-20221123071238-583f037c16b2 // indirect
-	github.com/acceldata-io/goutils/shellutils v0.0.0-20221123071023-3339e0dfbdb0 // indirect
+	github.com/acceldata-io/goutils/netutils v0.1.0 // indirect
+	github.com/acceldata-io/goutils/shellutils v0.1.0 // indirect
 	github.com/coreos/go-systemd/v22 v22.5.0 // indirect
 	github.com/gobwas/glob v0.2.3 // indirect
 	github.com/godbus/dbus/v5 v5.0.4 // indirect
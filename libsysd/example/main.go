@@ -15,8 +15,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 
 	"github.com/acceldata-io/goutils/libsysd"
 
@@ -52,27 +55,39 @@ func main() {
 	flaggy.AttachSubcommand(poll, 1)
 	flaggy.Parse()
 
-	if err := run(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sysWatcher, err := run(ctx)
+	if err != nil {
 		log.Fatal(err)
 	}
+	defer sysWatcher.Stop()
 	for {
 		select {
-		case a := <-libsysd.EventsOut:
+		case a, ok := <-sysWatcher.Events():
+			if !ok {
+				return
+			}
 			fmt.Println(a)
-		case err := <-libsysd.ErrCh:
+		case err, ok := <-sysWatcher.Errors():
+			if !ok {
+				return
+			}
 			log.Fatal(err)
 		}
 	}
 }
 
-func run() error {
+func run(ctx context.Context) (libsysd.Watcher, error) {
+	sysWatcher := libsysd.New(watchList, libsysd.WithContext(ctx))
 	switch {
 	case sub.Used:
-		SysWatcher := libsysd.New(watchList)
-		SysWatcher.Sub(libsysd.WithMetricsBufferLimit(metricBufferLimit), libsysd.WithPollInterval(pollInterval))
+		sysWatcher.Sub(libsysd.WithMetricsBufferLimit(metricBufferLimit), libsysd.WithPollInterval(pollInterval))
 	case poll.Used:
-		SysWatcher := libsysd.New(watchList)
-		SysWatcher.Poll(libsysd.WithMetricsBufferLimit(metricBufferLimit), libsysd.WithPollInterval(pollInterval))
+		sysWatcher.Poll(libsysd.WithMetricsBufferLimit(metricBufferLimit), libsysd.WithPollInterval(pollInterval))
+	default:
+		return nil, fmt.Errorf("either sub or poll subcommand must be used")
 	}
-	return nil
+	return sysWatcher, nil
 }
@@ -2,15 +2,14 @@ module github.com/acceldata-io/goutils/libsysd
 
 go 1.19
 
-
 require (
-	github.com/acceldata-io/goutils/netutils v0.0.0-20221123071238-583f037c16b2
+	github.com/acceldata-io/goutils/netutils v0.1.0
 	github.com/coreos/go-systemd/v22 v22.5.0
 	github.com/gobwas/glob v0.2.3
+	github.com/godbus/dbus/v5 v5.0.4
 )
 
 require (
 	github.com/Showmax/go-fqdn v1.0.0 // indirect
-	github.com/acceldata-io/goutils/shellutils v0.0.0-20221123071023-3339e0dfbdb0 // indirect
-	github.com/godbus/dbus/v5 v5.0.4 // indirect
+	github.com/acceldata-io/goutils/shellutils v0.1.0 // indirect
 )
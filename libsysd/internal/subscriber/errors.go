@@ -0,0 +1,21 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package subscriber holds the properties subscriber errors shared by the libsysd adapter and its libsysdtest fake
+package subscriber
+
+import "errors"
+
+// ErrAlreadySubscribed is returned when the properties subscriber of an adapter is already set
+var ErrAlreadySubscribed = errors.New("the adapter already has a properties subscriber")
@@ -0,0 +1,401 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package libsysdtest provides an in-memory systemd adapter to test libsysd watchers without D-Bus
+package libsysdtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/acceldata-io/goutils/libsysd/internal/subscriber"
+	"github.com/coreos/go-systemd/v22/dbus"
+	"github.com/gobwas/glob"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// DefaultVersion is the systemd version returned by GetVersion unless set with SetVersion
+const DefaultVersion = 250
+
+// Adapter is a scriptable fake implementing the libsysd Adapter interface.
+// Units and their properties live in memory, state transitions are emitted to the properties subscriber
+// like systemd does and errors can be injected per method. Like the libsysd adapter it has a single properties
+// subscriber and returns libsysd.ErrAlreadySubscribed to the next ones until it unsubscribes. It is safe for concurrent use
+type Adapter struct {
+	mutex      sync.Mutex
+	units      []string
+	properties map[string]map[string]interface{}
+	version    int
+	errs       map[string]error
+	calls      map[string]int
+	updateCh   chan *dbus.PropertiesUpdate
+	errCh      chan error
+	subscribed chan struct{}
+	closed     bool
+}
+
+// NewAdapter returns a new fake adapter without any unit
+func NewAdapter() *Adapter {
+	return &Adapter{
+		properties: map[string]map[string]interface{}{},
+		version:    DefaultVersion,
+		errs:       map[string]error{},
+		calls:      map[string]int{},
+		subscribed: make(chan struct{}),
+	}
+}
+
+// AddUnit adds a unit with its properties, ActiveState and SubState default to "active" and "running"
+func (a *Adapter) AddUnit(name string, properties map[string]interface{}) *Adapter {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	props := map[string]interface{}{
+		"Id":          name,
+		"LoadState":   "loaded",
+		"ActiveState": "active",
+		"SubState":    "running",
+	}
+	for p, v := range properties {
+		props[p] = v
+	}
+	if _, ok := a.properties[name]; !ok {
+		a.units = append(a.units, name)
+	}
+	a.properties[name] = props
+	return a
+}
+
+// SetProperties changes properties of a unit and emits them to the properties subscriber
+func (a *Adapter) SetProperties(name string, properties map[string]interface{}) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	props, ok := a.properties[name]
+	if !ok {
+		return unitNotFound(name)
+	}
+	for p, v := range properties {
+		props[p] = v
+	}
+	a.emit(name, properties)
+	return nil
+}
+
+// SetState moves a unit to the active and sub states and emits the transition to the properties subscriber
+func (a *Adapter) SetState(name, activeState, subState string) error {
+	return a.SetProperties(name, map[string]interface{}{"ActiveState": activeState, "SubState": subState})
+}
+
+// SetVersion sets the systemd version returned by GetVersion
+func (a *Adapter) SetVersion(version int) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.version = version
+}
+
+// InjectError makes every following call of the method, such as "ListUnitsByPattern", return err.
+// A nil error clears the injected one
+func (a *Adapter) InjectError(method string, err error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err == nil {
+		delete(a.errs, method)
+		return
+	}
+	a.errs[method] = err
+}
+
+// Emit sends the update to the properties subscriber.
+// Like go-systemd the update is dropped when the subscriber channel is full, Emit returns false if it was not sent
+func (a *Adapter) Emit(update *dbus.PropertiesUpdate) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.send(update)
+}
+
+// EmitError sends err to the error channel of the properties subscriber, blocking until it is received.
+// It returns false if there is no subscriber
+func (a *Adapter) EmitError(err error) bool {
+	a.mutex.Lock()
+	errCh := a.errCh
+	a.mutex.Unlock()
+	if errCh == nil {
+		return false
+	}
+	errCh <- err
+	return true
+}
+
+// WaitSubscribed blocks until a properties subscriber is set or the context is done
+func (a *Adapter) WaitSubscribed(ctx context.Context) error {
+	a.mutex.Lock()
+	subscribed := a.subscribed
+	a.mutex.Unlock()
+	select {
+	case <-subscribed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribed returns true if a properties subscriber is set
+func (a *Adapter) Subscribed() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.updateCh != nil
+}
+
+// Closed returns true once Close has been called
+func (a *Adapter) Closed() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.closed
+}
+
+// Calls returns how many times the method was called
+func (a *Adapter) Calls(method string) int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.calls[method]
+}
+
+func (a *Adapter) ListUnitsByPattern(states, patterns []string) ([]dbus.UnitStatus, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("ListUnitsByPattern"); err != nil {
+		return nil, err
+	}
+	units := make([]dbus.UnitStatus, 0)
+	for _, name := range a.units {
+		status := a.unitStatus(name)
+		if matchAny(states, status.ActiveState, status.SubState) && matchAny(patterns, status.Name) {
+			units = append(units, status)
+		}
+	}
+	return units, nil
+}
+
+func (a *Adapter) GetPropertiesForUnit(unit string) (map[string]interface{}, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("GetPropertiesForUnit"); err != nil {
+		return nil, err
+	}
+	return a.copyProperties(unit)
+}
+
+func (a *Adapter) GetPropertiesForAUnitType(unit, unitType string) (map[string]interface{}, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("GetPropertiesForAUnitType"); err != nil {
+		return nil, err
+	}
+	return a.copyProperties(unit)
+}
+
+func (a *Adapter) GetPropertyForService(unitName, propertyName string) (*dbus.Property, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("GetPropertyForService"); err != nil {
+		return nil, err
+	}
+	props, ok := a.properties[unitName]
+	if !ok {
+		return nil, unitNotFound(unitName)
+	}
+	value, ok := props[propertyName]
+	if !ok {
+		return nil, fmt.Errorf("unit %s has no property %s", unitName, propertyName)
+	}
+	return &dbus.Property{Name: propertyName, Value: godbus.MakeVariant(value)}, nil
+}
+
+func (a *Adapter) RestartService(serviceName string) (*dbus.UnitStatus, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.transition("RestartService", serviceName, "active", "running"); err != nil {
+		return nil, err
+	}
+	status := a.unitStatus(serviceName)
+	return &status, nil
+}
+
+func (a *Adapter) StartService(serviceName string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.transition("StartService", serviceName, "active", "running")
+}
+
+func (a *Adapter) StopService(serviceName string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.transition("StopService", serviceName, "inactive", "dead")
+}
+
+func (a *Adapter) ReloadService(serviceName string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("ReloadService"); err != nil {
+		return err
+	}
+	if _, ok := a.properties[serviceName]; !ok {
+		return unitNotFound(serviceName)
+	}
+	return nil
+}
+
+func (a *Adapter) SubscribeToUnitProperties(sysEventCh chan *dbus.PropertiesUpdate, errCh chan error) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("SubscribeToUnitProperties"); err != nil {
+		return err
+	}
+	if a.updateCh != nil {
+		return subscriber.ErrAlreadySubscribed
+	}
+	a.updateCh, a.errCh = sysEventCh, errCh
+	close(a.subscribed)
+	return nil
+}
+
+func (a *Adapter) UnsubscribeFromUnitProperties() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("UnsubscribeFromUnitProperties"); err != nil {
+		return err
+	}
+	a.unsubscribe()
+	return nil
+}
+
+func (a *Adapter) GetVersion() (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.call("GetVersion"); err != nil {
+		return -1, err
+	}
+	return a.version, nil
+}
+
+func (a *Adapter) ReloadDaemon() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.call("ReloadDaemon")
+}
+
+func (a *Adapter) Close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.calls["Close"]++
+	a.closed = true
+	a.unsubscribe()
+}
+
+// call records the call of the method and returns its injected error
+func (a *Adapter) call(method string) error {
+	a.calls[method]++
+	return a.errs[method]
+}
+
+func (a *Adapter) unsubscribe() {
+	if a.updateCh == nil {
+		return
+	}
+	a.updateCh, a.errCh = nil, nil
+	a.subscribed = make(chan struct{})
+}
+
+func (a *Adapter) transition(method, name, activeState, subState string) error {
+	if err := a.call(method); err != nil {
+		return err
+	}
+	props, ok := a.properties[name]
+	if !ok {
+		return unitNotFound(name)
+	}
+	changed := map[string]interface{}{"ActiveState": activeState, "SubState": subState}
+	for p, v := range changed {
+		props[p] = v
+	}
+	a.emit(name, changed)
+	return nil
+}
+
+func (a *Adapter) emit(name string, changed map[string]interface{}) {
+	update := &dbus.PropertiesUpdate{UnitName: name, Changed: map[string]godbus.Variant{}}
+	for p, v := range changed {
+		update.Changed[p] = godbus.MakeVariant(v)
+	}
+	a.send(update)
+}
+
+// send writes the update without blocking, reporting a full channel on the error channel like go-systemd
+func (a *Adapter) send(update *dbus.PropertiesUpdate) bool {
+	if a.updateCh == nil {
+		return false
+	}
+	select {
+	case a.updateCh <- update:
+		return true
+	default:
+		select {
+		case a.errCh <- fmt.Errorf("update channel is full"):
+		default:
+		}
+		return false
+	}
+}
+
+func (a *Adapter) unitStatus(name string) dbus.UnitStatus {
+	props := a.properties[name]
+	status := dbus.UnitStatus{Name: name}
+	status.Description, _ = props["Description"].(string)
+	status.LoadState, _ = props["LoadState"].(string)
+	status.ActiveState, _ = props["ActiveState"].(string)
+	status.SubState, _ = props["SubState"].(string)
+	return status
+}
+
+func (a *Adapter) copyProperties(name string) (map[string]interface{}, error) {
+	props, ok := a.properties[name]
+	if !ok {
+		return nil, unitNotFound(name)
+	}
+	copied := make(map[string]interface{}, len(props))
+	for p, v := range props {
+		copied[p] = v
+	}
+	return copied, nil
+}
+
+// matchAny returns true if one of the glob patterns matches one of the values
+func matchAny(patterns []string, values ...string) bool {
+	for _, pattern := range patterns {
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			continue
+		}
+		for _, value := range values {
+			if g.Match(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func unitNotFound(name string) error {
+	return fmt.Errorf("unit %s not found", name)
+}
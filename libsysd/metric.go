@@ -15,10 +15,22 @@
 // Package libsysd is a simple wrapper on top of go-systemd module
 package libsysd
 
+// EventType tells what a SystemDEvent reports
+type EventType int
+
+const (
+	// UnitEvent reports properties of a systemd unit
+	UnitEvent EventType = iota
+	// ReconnectEvent reports that the connection to systemd was lost and restored,
+	// it has no unit and is followed by the current properties of every watched unit
+	ReconnectEvent
+)
+
 // SystemDEvent represents a single systemd service event
 type SystemDEvent struct {
 	Timestamp      int64                  // Timestamp of when did we receive the event
 	PropertyUpdate map[string]interface{} // Property systemd property name:value/systemd property values map
 	UnitName       string                 // UnitName of the systemd service
 	Hostname       string                 // Hostname of the current machine
+	Type           EventType              // Type of the event, UnitEvent unless the connection to systemd was restored
 }
@@ -15,46 +15,51 @@
 package libsysd
 
 import (
+	"context"
 	"fmt"
 	"time"
-
-	"github.com/acceldata-io/goutils/netutils"
 )
 
-func (w *watcher) poll() {
+func (w *watcher) poll(ctx context.Context) {
 	if len(w.watchList) < 1 {
-		ErrCh <- fmt.Errorf("no systemd services were provided")
+		w.sendError(ctx, fmt.Errorf("no systemd services were provided"))
 		return
 	}
 	for _, unit := range w.watchList {
 		unitList, err := w.systemD.ListUnitsByPattern(states, []string{unit})
 		if err != nil {
-			ErrCh <- err
+			w.sendError(ctx, err)
 			return
 		}
 		if len(unitList) < 1 {
-			ErrCh <- fmt.Errorf("%s unit listed cannot be found", unit)
+			w.sendError(ctx, fmt.Errorf("%s unit listed cannot be found", unit))
 			return
 		}
 	}
 	pollTicker := time.NewTicker(time.Duration(w.pollInterval) * time.Second)
-	for ; true; <-pollTicker.C {
+	defer pollTicker.Stop()
+	for {
 		for _, unit := range w.watchList {
 			event, err := w.systemD.GetPropertiesForUnit(unit)
 			if err != nil {
-				ErrCh <- err
-			}
-			hostName, err := netutils.GetHostName(w.hostnameMethod, 20)
-			if err != nil {
-				hostName = "localhost"
+				if !w.sendError(ctx, err) {
+					return
+				}
 			}
 			e := &SystemDEvent{
 				Timestamp:      time.Now().UnixMilli(),
 				PropertyUpdate: event,
 				UnitName:       unit,
-				Hostname:       hostName,
+				Hostname:       w.hostName(),
 			}
-			EventsOut <- e
+			if !w.sendEvent(ctx, e) {
+				return
+			}
+		}
+		select {
+		case <-pollTicker.C:
+		case <-ctx.Done():
+			return
 		}
 	}
 }
@@ -0,0 +1,147 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrReconnected is sent on the error channel of the properties subscriber once the adapter has reconnected
+	// to systemd and subscribed again, property updates may have been missed while it was disconnected
+	ErrReconnected = errors.New("reconnected to systemd")
+	// ErrDisconnected is returned while the connection to systemd is lost and cannot be reopened yet
+	ErrDisconnected = errors.New("disconnected from systemd")
+)
+
+const (
+	healthCheckInterval = time.Second
+	connectTimeout      = 10 * time.Second
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 30 * time.Second
+)
+
+// ensureConnected returns a live connection, reopening it when it was lost and setting the properties subscriber
+// again. Failed attempts are retried with an exponential backoff, the mutex must be held
+func (s *systemDAdapter) ensureConnected() (systemdConn, error) {
+	if s.conn != nil && s.conn.Connected() {
+		return s.conn, nil
+	}
+	if s.connect == nil {
+		if s.conn == nil {
+			return nil, fmt.Errorf("no connection provided to the systemd adapter")
+		}
+		return nil, fmt.Errorf("%w: the connection provided to the adapter was closed", ErrDisconnected)
+	}
+	if s.conn != nil {
+		// systemd or the bus went away, e.g. with daemon-reexec
+		s.conn.Close()
+		s.conn = nil
+		s.disconnected = true
+	}
+	if wait := time.Until(s.retryAt); wait > 0 {
+		return nil, fmt.Errorf("%w: next attempt in %v", ErrDisconnected, wait.Round(time.Millisecond))
+	}
+
+	conn, err := s.dial()
+	if err == nil && s.updateCh != nil {
+		if err = subscribe(conn, s.updateCh, s.errCh); err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		s.backoff *= 2
+		if s.backoff < minReconnectBackoff {
+			s.backoff = minReconnectBackoff
+		}
+		if s.backoff > maxReconnectBackoff {
+			s.backoff = maxReconnectBackoff
+		}
+		s.retryAt = time.Now().Add(s.backoff)
+		return nil, err
+	}
+
+	s.conn = conn
+	s.backoff, s.retryAt = 0, time.Time{}
+	// systemd may have been upgraded before being reexecuted
+	s.systemDVersion = 0
+	if s.disconnected && s.errCh != nil {
+		go notifySubscriber(s.errCh, s.stopMonitor, ErrReconnected)
+	}
+	s.disconnected = false
+	return conn, nil
+}
+
+// dial opens a new connection, giving up after the dial timeout as the mutex is held meanwhile.
+// go-systemd closes the connection once its context is done, so the context is only canceled when the timeout fires
+func (s *systemDAdapter) dial() (systemdConn, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	timer := time.AfterFunc(s.dialTimeout, cancel)
+	conn, err := s.connect(ctx)
+	if timer.Stop() {
+		return conn, err
+	}
+	if err == nil {
+		conn.Close()
+	}
+	return nil, fmt.Errorf("connecting to systemd timed out after %v", s.dialTimeout)
+}
+
+// monitor checks the connection while the properties subscriber is set, so a lost connection is reopened
+// even when no call is made to the adapter. The loss of a connection provided by the caller is reported once
+// as it is never reopened
+func (s *systemDAdapter) monitor(stop chan struct{}) {
+	ticker := time.NewTicker(s.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		s.mutex.Lock()
+		select {
+		case <-stop:
+			// unsubscribed or closed while waiting for the mutex, a new connection would never be closed
+			s.mutex.Unlock()
+			return
+		default:
+		}
+		errCh := s.errCh
+		_, err := s.ensureConnected()
+		reopened := s.connect != nil
+		s.mutex.Unlock()
+		if err != nil && !reopened {
+			if errCh != nil {
+				notifySubscriber(errCh, stop, err)
+			}
+			return
+		}
+		if err != nil && !errors.Is(err, ErrDisconnected) && errCh != nil {
+			notifySubscriber(errCh, stop, fmt.Errorf("reconnecting to systemd: %w", err))
+		}
+	}
+}
+
+// notifySubscriber sends err to the subscriber unless it unsubscribes first
+func notifySubscriber(errCh chan error, stop chan struct{}, err error) {
+	select {
+	case errCh <- err:
+	case <-stop:
+	}
+}
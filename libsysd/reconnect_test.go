@@ -0,0 +1,360 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+// fakeBus hands out fake connections to an in-memory systemd
+type fakeBus struct {
+	mutex     sync.Mutex
+	conns     []*fakeConn
+	dialErr   error
+	dialDelay time.Duration
+}
+
+func (b *fakeBus) dial(ctx context.Context) (systemdConn, error) {
+	b.mutex.Lock()
+	delay := b.dialDelay
+	b.mutex.Unlock()
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.dialErr != nil {
+		return nil, b.dialErr
+	}
+	conn := &fakeConn{connected: true}
+	b.conns = append(b.conns, conn)
+	return conn, nil
+}
+
+func (b *fakeBus) setDialErr(err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.dialErr = err
+}
+
+func (b *fakeBus) dials() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return len(b.conns)
+}
+
+func (b *fakeBus) last() *fakeConn {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.conns[len(b.conns)-1]
+}
+
+func newFakeBusAdapter(bus *fakeBus) *systemDAdapter {
+	return &systemDAdapter{
+		mutex:          &sync.Mutex{},
+		connect:        bus.dial,
+		healthInterval: 10 * time.Millisecond,
+		dialTimeout:    time.Second,
+	}
+}
+
+// fakeConn is a connection to an in-memory systemd running nginx.service
+type fakeConn struct {
+	mutex      sync.Mutex
+	connected  bool
+	closed     bool
+	subscribed bool
+	updateCh   chan<- *dbus.PropertiesUpdate
+	errCh      chan<- error
+}
+
+func (c *fakeConn) drop() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connected = false
+}
+
+func (c *fakeConn) emit(update *dbus.PropertiesUpdate) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.updateCh != nil {
+		c.updateCh <- update
+	}
+}
+
+func (c *fakeConn) state() (closed, subscribed bool, updateCh chan<- *dbus.PropertiesUpdate) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.closed, c.subscribed, c.updateCh
+}
+
+func (c *fakeConn) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.connected = false
+	c.closed = true
+}
+
+func (c *fakeConn) Connected() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.connected
+}
+
+func (c *fakeConn) Subscribe() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.subscribed = true
+	return nil
+}
+
+func (c *fakeConn) SetPropertiesSubscriber(updateCh chan<- *dbus.PropertiesUpdate, errCh chan<- error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.updateCh, c.errCh = updateCh, errCh
+}
+
+func (c *fakeConn) GetManagerProperty(prop string) (string, error) {
+	return `"250.4-1"`, nil
+}
+
+func (c *fakeConn) GetAllPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error) {
+	return map[string]interface{}{"ActiveState": "active"}, nil
+}
+
+func (c *fakeConn) GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error) {
+	return map[string]interface{}{}, nil
+}
+
+func (c *fakeConn) GetServicePropertyContext(ctx context.Context, service string, propertyName string) (*dbus.Property, error) {
+	return &dbus.Property{Name: propertyName, Value: godbus.MakeVariant("")}, nil
+}
+
+func (c *fakeConn) ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error) {
+	return []dbus.UnitStatus{{Name: "nginx.service", ActiveState: "active", SubState: "running"}}, nil
+}
+
+func (c *fakeConn) ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]dbus.UnitStatus, error) {
+	units, _ := c.ListUnitsContext(ctx)
+	return filterUnits(units, states, patterns), nil
+}
+
+func (c *fakeConn) StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	go func() { ch <- "done" }()
+	return 1, nil
+}
+
+func (c *fakeConn) StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.StartUnitContext(ctx, name, mode, ch)
+}
+
+func (c *fakeConn) ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.StartUnitContext(ctx, name, mode, ch)
+}
+
+func (c *fakeConn) RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error) {
+	return c.StartUnitContext(ctx, name, mode, ch)
+}
+
+func (c *fakeConn) ReloadContext(ctx context.Context) error {
+	return nil
+}
+
+func TestAdapterReconnect(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+
+	if version, err := s.GetVersion(); err != nil || version != 250 {
+		t.Fatalf("GetVersion() got = %d, %v, want 250", version, err)
+	}
+	first := bus.last()
+	first.drop()
+	if _, err := s.GetPropertiesForUnit("nginx.service"); err != nil {
+		t.Fatalf("GetPropertiesForUnit() after a lost connection error = %v", err)
+	}
+	if closed, _, _ := first.state(); !closed || bus.dials() != 2 {
+		t.Errorf("a lost connection should be closed and reopened, got %d dials", bus.dials())
+	}
+}
+
+func TestAdapterReconnectBackoff(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	if _, err := s.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+
+	dialErr := errors.New("connection refused")
+	bus.setDialErr(dialErr)
+	bus.last().drop()
+	if _, err := s.GetVersion(); !errors.Is(err, dialErr) {
+		t.Errorf("GetVersion() error = %v, want %v", err, dialErr)
+	}
+	if _, err := s.GetVersion(); !errors.Is(err, ErrDisconnected) {
+		t.Errorf("GetVersion() during the backoff error = %v, want ErrDisconnected", err)
+	}
+
+	bus.setDialErr(nil)
+	time.Sleep(2 * minReconnectBackoff)
+	if _, err := s.GetVersion(); err != nil {
+		t.Errorf("GetVersion() after the backoff error = %v", err)
+	}
+	if bus.dials() != 2 {
+		t.Errorf("got %d connections, want 2", bus.dials())
+	}
+}
+
+func TestAdapterDialTimeout(t *testing.T) {
+	bus := &fakeBus{dialDelay: time.Second}
+	s := newFakeBusAdapter(bus)
+	s.dialTimeout = 10 * time.Millisecond
+
+	start := time.Now()
+	if _, err := s.GetVersion(); err == nil {
+		t.Fatalf("GetVersion() expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("GetVersion() waited %v for the connection, want the dial timeout", elapsed)
+	}
+	if bus.dials() != 0 {
+		t.Errorf("a timed out dial should not open a connection")
+	}
+}
+
+func TestAdapterMonitorStopped(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	if err := s.SubscribeToUnitProperties(make(chan *dbus.PropertiesUpdate, 1), make(chan error)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the monitor ticks while the mutex is held, like during Close, and waits for it
+	s.mutex.Lock()
+	bus.last().drop()
+	time.Sleep(5 * s.healthInterval)
+	s.unsubscribe()
+	s.mutex.Unlock()
+
+	time.Sleep(5 * s.healthInterval)
+	if bus.dials() != 1 {
+		t.Errorf("the monitor reconnected after being stopped, got %d dials", bus.dials())
+	}
+}
+
+func TestConnAdapterLost(t *testing.T) {
+	conn := &fakeConn{connected: true}
+	s := &systemDAdapter{
+		conn:           conn,
+		mutex:          &sync.Mutex{},
+		healthInterval: 10 * time.Millisecond,
+	}
+	defer s.Close()
+	errCh := make(chan error)
+	if err := s.SubscribeToUnitProperties(make(chan *dbus.PropertiesUpdate, 1), errCh); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.drop()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrDisconnected) {
+			t.Fatalf("got error %v, want ErrDisconnected", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the loss of the connection was not reported")
+	}
+	select {
+	case err := <-errCh:
+		t.Errorf("the loss of the connection should be reported once, got %v", err)
+	case <-time.After(10 * s.healthInterval):
+	}
+}
+
+func TestAdapterResubscribe(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	updateCh := make(chan *dbus.PropertiesUpdate, 1)
+	errCh := make(chan error)
+	if err := s.SubscribeToUnitProperties(updateCh, errCh); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	bus.last().drop()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrReconnected) {
+			t.Fatalf("got error %v, want ErrReconnected", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the health monitor did not reconnect")
+	}
+	_, subscribed, resubscribed := bus.last().state()
+	if bus.dials() != 2 || !subscribed || resubscribed == nil {
+		t.Fatalf("the new connection should be subscribed, got %d dials", bus.dials())
+	}
+
+	if err := s.UnsubscribeFromUnitProperties(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, updateCh := bus.last().state(); updateCh != nil {
+		t.Errorf("UnsubscribeFromUnitProperties() should remove the subscriber")
+	}
+}
+
+func TestSubReconnectEvent(t *testing.T) {
+	bus := &fakeBus{}
+	w := newTestWatcher(newFakeBusAdapter(bus), []string{"nginx"})
+	w.Sub()
+	defer w.Stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if bus.dials() > 0 {
+			if _, subscribed, _ := bus.last().state(); subscribed {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Sub() did not subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	bus.last().drop()
+
+	if e := nextEvent(t, w); e.Type != ReconnectEvent || e.UnitName != "" {
+		t.Errorf("Sub() got = %+v, want a reconnect event", e)
+	}
+	if e := nextEvent(t, w); e.Type != UnitEvent || e.UnitName != "nginx.service" || e.PropertyUpdate["ActiveState"] != "active" {
+		t.Errorf("Sub() got = %+v, want the current nginx.service properties", e)
+	}
+
+	bus.last().emit(&dbus.PropertiesUpdate{
+		UnitName: "nginx.service",
+		Changed:  map[string]godbus.Variant{"ActiveState": godbus.MakeVariant("failed")},
+	})
+	if e := nextEvent(t, w); e.UnitName != "nginx.service" || e.PropertyUpdate["ActiveState"] != "failed" {
+		t.Errorf("Sub() after the reconnection got = %+v, want a failed nginx.service event", e)
+	}
+}
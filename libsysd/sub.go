@@ -15,38 +15,49 @@
 package libsysd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"github.com/acceldata-io/goutils/netutils"
-
 	"github.com/coreos/go-systemd/v22/dbus"
 )
 
-func (w *watcher) sub() {
+// propertiesChannelSize is the size of the channel of the properties subscriber, the updates are moved
+// to the events buffer right away so it only absorbs bursts
+const propertiesChannelSize = 64
+
+// errUpdateChannelFull is the message of the error sent by go-systemd when it drops an update
+const errUpdateChannelFull = "update channel is full"
+
+func (w *watcher) sub(ctx context.Context) {
 	// first sub is to poll then wait for change
 	if len(w.watchList) < 1 {
-		ErrCh <- fmt.Errorf("no systemd services were provided")
+		w.sendError(ctx, fmt.Errorf("no systemd services were provided"))
 		return
 	}
 	for _, unit := range w.watchList {
 		unitList, err := w.systemD.ListUnitsByPattern(states, []string{unit})
 		if err != nil {
-			ErrCh <- err
+			w.sendError(ctx, err)
 			return
 		}
 		if len(unitList) < 1 {
-			ErrCh <- fmt.Errorf("%s unit listed cannot be found", unit)
+			w.sendError(ctx, fmt.Errorf("%s unit listed cannot be found", unit))
 			return
 		}
 	}
 	// w.poll()
-	UpdatePropertiesChannel := make(chan *dbus.PropertiesUpdate)
-	ErrChannel := make(chan error)
+	// go-systemd drops updates when this channel is full and reports it on the error channel if there is room,
+	// the drops are counted in Stats
+	UpdatePropertiesChannel := make(chan *dbus.PropertiesUpdate, propertiesChannelSize)
+	ErrChannel := make(chan error, propertiesChannelSize)
 	err := w.systemD.SubscribeToUnitProperties(UpdatePropertiesChannel, ErrChannel)
 	if err != nil {
-		ErrCh <- err
+		w.sendError(ctx, err)
+		return
 	}
+	defer w.systemD.UnsubscribeFromUnitProperties()
 	for {
 		select {
 		case update := <-UpdatePropertiesChannel:
@@ -56,23 +67,63 @@ func (w *watcher) sub() {
 					for p, v := range update.Changed {
 						event[p] = v.Value()
 					}
-					hostName, err := netutils.GetHostName(w.hostnameMethod, 20)
-					if err != nil {
-						hostName = "localhost"
-					}
 					e := &SystemDEvent{
 						Timestamp:      time.Now().UnixMilli(),
 						PropertyUpdate: event,
 						UnitName:       unitName,
-						Hostname:       hostName,
+						Hostname:       w.hostName(),
+					}
+					if !w.sendEvent(ctx, e) {
+						return
 					}
-					EventsOut <- e
 				}
 			}
 		case err = <-ErrChannel:
-			if err != nil {
-				ErrCh <- err
+			if errors.Is(err, ErrReconnected) {
+				if !w.resync(ctx) {
+					return
+				}
+			} else if err != nil && err.Error() == errUpdateChannelFull {
+				w.queue.drop()
+			} else if err != nil {
+				if !w.sendError(ctx, err) {
+					return
+				}
 			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// resync sends a reconnect event followed by the current properties of every watched unit,
+// as the updates sent while the connection to systemd was lost are missed
+func (w *watcher) resync(ctx context.Context) bool {
+	e := &SystemDEvent{
+		Timestamp: time.Now().UnixMilli(),
+		Hostname:  w.hostName(),
+		Type:      ReconnectEvent,
+	}
+	if !w.sendEvent(ctx, e) {
+		return false
+	}
+	for _, unitName := range w.watchList {
+		properties, err := w.systemD.GetPropertiesForUnit(unitName)
+		if err != nil {
+			if !w.sendError(ctx, err) {
+				return false
+			}
+			continue
+		}
+		e := &SystemDEvent{
+			Timestamp:      time.Now().UnixMilli(),
+			PropertyUpdate: properties,
+			UnitName:       unitName,
+			Hostname:       w.hostName(),
+		}
+		if !w.sendEvent(ctx, e) {
+			return false
 		}
 	}
+	return true
 }
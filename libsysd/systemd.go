@@ -20,13 +20,19 @@ import (
 	"regexp"
 	"strconv"
 	"sync"
+	"time"
 
+	"github.com/acceldata-io/goutils/libsysd/internal/subscriber"
 	"github.com/coreos/go-systemd/v22/dbus"
 	"github.com/gobwas/glob"
 )
 
 var states = []string{"active", "activating", "failed", "inactive", "deactivating", "maintenance", "reloading"}
 
+// ErrAlreadySubscribed is returned when the properties subscriber of an adapter is already set.
+// go-systemd keeps a single subscriber per connection, so an adapter can only be shared by one Sub watcher
+var ErrAlreadySubscribed = subscriber.ErrAlreadySubscribed
+
 const versionProperty = "Version"
 
 // Adapter implements a systemd adapter
@@ -40,101 +46,274 @@ type Adapter interface {
 	StopService(serviceName string) error
 	ReloadService(serviceName string) error
 	SubscribeToUnitProperties(sysEventCh chan *dbus.PropertiesUpdate, errCh chan error) error
+	UnsubscribeFromUnitProperties() error
 	GetVersion() (int, error)
 	ReloadDaemon() error
 	Close()
 }
 
+// Bus selects how an adapter connects to systemd
+type Bus int
+
+const (
+	// PrivateBus connects to the private socket of systemd, which works when the system bus is down but requires root
+	PrivateBus Bus = iota
+	// SystemBus connects to systemd through the system bus
+	SystemBus
+	// UserBus connects to the user instance of systemd through the session bus, like systemctl --user
+	UserBus
+)
+
+func (b Bus) String() string {
+	switch b {
+	case PrivateBus:
+		return "private"
+	case SystemBus:
+		return "system"
+	case UserBus:
+		return "user"
+	default:
+		return fmt.Sprintf("Bus(%d)", int(b))
+	}
+}
+
+// systemdConn is the part of *dbus.Conn used by the adapter
+type systemdConn interface {
+	Close()
+	Connected() bool
+	Subscribe() error
+	SetPropertiesSubscriber(updateCh chan<- *dbus.PropertiesUpdate, errCh chan<- error)
+	GetManagerProperty(prop string) (string, error)
+	GetAllPropertiesContext(ctx context.Context, unit string) (map[string]interface{}, error)
+	GetUnitTypePropertiesContext(ctx context.Context, unit string, unitType string) (map[string]interface{}, error)
+	GetServicePropertyContext(ctx context.Context, service string, propertyName string) (*dbus.Property, error)
+	ListUnitsContext(ctx context.Context) ([]dbus.UnitStatus, error)
+	ListUnitsByPatternsContext(ctx context.Context, states []string, patterns []string) ([]dbus.UnitStatus, error)
+	StartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	StopUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	RestartUnitContext(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+	ReloadContext(ctx context.Context) error
+}
+
+// systemDAdapter is safe for concurrent use, the mutex guards the connection, its state and the subscriber
 type systemDAdapter struct {
-	conn           *dbus.Conn
+	conn           systemdConn
 	systemDVersion int
 	mutex          *sync.Mutex
+	bus            Bus
+	// connect opens the connection, nil when the connection is provided by the caller
+	connect func(ctx context.Context) (systemdConn, error)
+
+	// the connection is checked before every call and by the health monitor while subscribed,
+	// a lost connection is reopened with backoff
+	disconnected   bool
+	backoff        time.Duration
+	retryAt        time.Time
+	healthInterval time.Duration
+	dialTimeout    time.Duration
+
+	// properties subscriber set again on every new connection
+	updateCh    chan *dbus.PropertiesUpdate
+	errCh       chan error
+	stopMonitor chan struct{}
 }
 
+// Close unsubscribes and closes the connection opened by the adapter, the next call opens a new one.
+// A connection passed to NewConnAdapter is left open and used again by the next call
 func (s *systemDAdapter) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unsubscribe()
+	if s.connect == nil {
+		return
+	}
 	if s.conn != nil {
-		s.mutex.Lock()
 		s.conn.Close()
-		s.mutex.Unlock()
+		s.conn = nil
 	}
+	s.systemDVersion = 0
+	s.disconnected = false
+	s.backoff, s.retryAt = 0, time.Time{}
 }
 
-// NewSystemDAdapter provides a new systemd adapter
+// NewSystemDAdapter provides a new systemd adapter connected to the private socket of systemd
 func NewSystemDAdapter() Adapter {
+	return NewAdapter(PrivateBus)
+}
+
+// NewAdapter provides a new systemd adapter connecting to the bus, the connection is opened on first use
+func NewAdapter(bus Bus) Adapter {
+	connect := dbus.NewSystemdConnectionContext
+	switch bus {
+	case SystemBus:
+		connect = dbus.NewSystemConnectionContext
+	case UserBus:
+		connect = dbus.NewUserConnectionContext
+	}
 	return &systemDAdapter{
 		conn:           nil,
 		systemDVersion: 0,
 		mutex:          &sync.Mutex{},
+		bus:            bus,
+		connect:        dialer(connect),
+		healthInterval: healthCheckInterval,
+		dialTimeout:    connectTimeout,
+	}
+}
+
+// dialer adapts a go-systemd connection constructor, without returning a nil *dbus.Conn as a non-nil systemdConn
+func dialer(connect func(ctx context.Context) (*dbus.Conn, error)) func(ctx context.Context) (systemdConn, error) {
+	return func(ctx context.Context) (systemdConn, error) {
+		conn, err := connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return conn, nil
+	}
+}
+
+// NewSystemBusAdapter provides a new systemd adapter connected through the system bus
+func NewSystemBusAdapter() Adapter {
+	return NewAdapter(SystemBus)
+}
+
+// NewUserBusAdapter provides a new systemd adapter connected to the user instance of systemd
+func NewUserBusAdapter() Adapter {
+	return NewAdapter(UserBus)
+}
+
+// NewPrivateSocketAdapter provides a new systemd adapter connected to the private socket of systemd
+func NewPrivateSocketAdapter() Adapter {
+	return NewAdapter(PrivateBus)
+}
+
+// NewConnAdapter provides a new systemd adapter using the connection of the caller.
+// The connection belongs to the caller, it is not closed by Close nor reopened when it is lost
+func NewConnAdapter(conn *dbus.Conn) Adapter {
+	s := &systemDAdapter{
+		systemDVersion: 0,
+		mutex:          &sync.Mutex{},
+		healthInterval: healthCheckInterval,
+	}
+	if conn != nil {
+		s.conn = conn
 	}
+	return s
 }
 
 var reVersion = regexp.MustCompile(`\d\d\d`)
 
 func (s *systemDAdapter) GetPropertiesForUnit(unit string) (map[string]interface{}, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	return s.conn.GetAllPropertiesContext(context.Background(), unit)
+	return conn.GetAllPropertiesContext(context.Background(), unit)
 }
 
 func (s *systemDAdapter) GetPropertiesForAUnitType(unit, unitType string) (map[string]interface{}, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	return s.conn.GetUnitTypePropertiesContext(context.Background(), unit, unitType)
+	return conn.GetUnitTypePropertiesContext(context.Background(), unit, unitType)
 }
 
 func (s *systemDAdapter) GetPropertyForService(unitName, propertyName string) (*dbus.Property, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	return s.conn.GetServicePropertyContext(context.Background(), unitName, propertyName)
+	return conn.GetServicePropertyContext(context.Background(), unitName, propertyName)
 }
 
+// SubscribeToUnitProperties sets the properties subscriber, ErrAlreadySubscribed is returned until the current one unsubscribes
 func (s *systemDAdapter) SubscribeToUnitProperties(sysEvent chan *dbus.PropertiesUpdate, errCh chan error) error {
-	err := s.getConnection()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.updateCh != nil {
+		return ErrAlreadySubscribed
+	}
+	conn, err := s.ensureConnected()
 	if err != nil {
 		return err
 	}
-	s.conn.SetPropertiesSubscriber(sysEvent, errCh)
+	if err := subscribe(conn, sysEvent, errCh); err != nil {
+		return err
+	}
+	s.updateCh, s.errCh = sysEvent, errCh
+	if s.stopMonitor == nil {
+		s.stopMonitor = make(chan struct{})
+		go s.monitor(s.stopMonitor)
+	}
+	return nil
+}
+
+func (s *systemDAdapter) UnsubscribeFromUnitProperties() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.unsubscribe()
+	return nil
+}
+
+// unsubscribe stops the health monitor and removes the properties subscriber, the mutex must be held
+func (s *systemDAdapter) unsubscribe() {
+	if s.stopMonitor != nil {
+		close(s.stopMonitor)
+		s.stopMonitor = nil
+	}
+	if s.updateCh == nil {
+		return
+	}
+	s.updateCh, s.errCh = nil, nil
+	if s.conn != nil {
+		s.conn.SetPropertiesSubscriber(nil, nil)
+	}
+}
+
+// subscribe asks systemd to send the unit signals to the connection and sets the properties subscriber
+func subscribe(conn systemdConn, sysEvent chan *dbus.PropertiesUpdate, errCh chan error) error {
+	if err := conn.Subscribe(); err != nil {
+		return err
+	}
+	conn.SetPropertiesSubscriber(sysEvent, errCh)
 	return nil
 }
 
 func (s *systemDAdapter) ListUnitsByPattern(states, patterns []string) ([]dbus.UnitStatus, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return nil, err
 	}
-	version, err := s.getVersion()
+	version, err := s.getVersion(conn)
 	if err != nil {
 		return nil, err
 	}
 	if version >= 230 {
-		return s.conn.ListUnitsByPatternsContext(context.Background(), states, patterns)
+		return conn.ListUnitsByPatternsContext(context.Background(), states, patterns)
 	}
-	return s.listUnitsAndFilterPatterns(states, patterns)
+	return s.listUnitsAndFilterPatterns(conn, states, patterns)
 }
 
-func (s *systemDAdapter) getConnection() error {
-	if s.conn == nil {
-		s.mutex.Lock()
-		var err error
-		s.conn, err = dbus.NewSystemdConnectionContext(context.Background())
-		s.mutex.Unlock()
-		return err
-	}
-	return nil
+// getConnection returns the connection to systemd, opening it on first use or when it was lost
+func (s *systemDAdapter) getConnection() (systemdConn, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.ensureConnected()
 }
 
-func (s *systemDAdapter) listUnitsAndFilterPatterns(states, patterns []string) ([]dbus.UnitStatus, error) {
-	units, err := s.conn.ListUnitsContext(context.Background())
+func (s *systemDAdapter) listUnitsAndFilterPatterns(conn systemdConn, states, patterns []string) ([]dbus.UnitStatus, error) {
+	units, err := conn.ListUnitsContext(context.Background())
 	if err != nil {
 		return nil, err
 	}
+	return filterUnits(units, states, patterns), nil
+}
 
+// filterUnits returns the units whose active or sub state matches one of the states
+// and whose name matches one of the patterns, like ListUnitsByPatterns does on systemd 230 and later
+func filterUnits(units []dbus.UnitStatus, states, patterns []string) []dbus.UnitStatus {
 	compiledStates := getCompiledMapGlob(states)
 	compiledPatterns := getCompiledMapGlob(patterns)
 
@@ -157,7 +336,7 @@ func (s *systemDAdapter) listUnitsAndFilterPatterns(states, patterns []string) (
 			matchedUnits = append(matchedUnits, unit)
 		}
 	}
-	return matchedUnits, nil
+	return matchedUnits
 }
 
 func getCompiledMapGlob(arr []string) map[string]glob.Glob {
@@ -168,50 +347,55 @@ func getCompiledMapGlob(arr []string) map[string]glob.Glob {
 	return compiledMap
 }
 
-func (s *systemDAdapter) getVersion() (int, error) {
-	if s.systemDVersion != 0 {
-		return s.systemDVersion, nil
-	}
+func (s *systemDAdapter) getVersion(conn systemdConn) (int, error) {
 	s.mutex.Lock()
-	version, err := s.conn.GetManagerProperty(versionProperty)
+	cached := s.systemDVersion
+	s.mutex.Unlock()
+	if cached != 0 {
+		return cached, nil
+	}
+
+	// the D-Bus call is made without holding the mutex, concurrent callers may both ask for the version
+	version, err := conn.GetManagerProperty(versionProperty)
 	if err != nil {
-		s.mutex.Unlock()
 		return 0, err
 	}
 
 	major := reVersion.FindString(version)
 	if major == "" {
-		s.mutex.Unlock()
 		return 0, fmt.Errorf("couldn't parse systemd version string '%s'", version)
 	}
 
 	ver, err := strconv.Atoi(major)
 	if err != nil {
-		s.mutex.Unlock()
 		return 0, fmt.Errorf("couldn't parse systemd version string '%s': %v", version, err)
 	}
-	s.systemDVersion = ver
+	s.mutex.Lock()
+	// a version read on a connection replaced in between may be outdated
+	if s.conn == conn {
+		s.systemDVersion = ver
+	}
 	s.mutex.Unlock()
-	return s.systemDVersion, nil
+	return ver, nil
 }
 
 func (s *systemDAdapter) GetVersion() (int, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return -1, err
 	}
 
-	return s.getVersion()
+	return s.getVersion(conn)
 }
 
 func (s *systemDAdapter) RestartService(serviceName string) (*dbus.UnitStatus, error) {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return nil, err
 	}
 
 	wait := make(chan string)
-	_, err = s.conn.RestartUnitContext(context.Background(), serviceName, "replace", wait)
+	_, err = conn.RestartUnitContext(context.Background(), serviceName, "replace", wait)
 	if err != nil {
 		return nil, err
 	}
@@ -221,23 +405,23 @@ func (s *systemDAdapter) RestartService(serviceName string) (*dbus.UnitStatus, e
 }
 
 func (s *systemDAdapter) ReloadDaemon() error {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return err
 	}
 
-	err = s.conn.ReloadContext(context.Background())
+	err = conn.ReloadContext(context.Background())
 	return err
 }
 
 func (s *systemDAdapter) StartService(serviceName string) error {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return err
 	}
 
 	wait := make(chan string)
-	_, err = s.conn.StartUnitContext(context.Background(), serviceName, "replace", wait)
+	_, err = conn.StartUnitContext(context.Background(), serviceName, "replace", wait)
 	if err != nil {
 		return err
 	}
@@ -246,13 +430,13 @@ func (s *systemDAdapter) StartService(serviceName string) error {
 }
 
 func (s *systemDAdapter) StopService(serviceName string) error {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return err
 	}
 
 	wait := make(chan string)
-	_, err = s.conn.StopUnitContext(context.Background(), serviceName, "replace", wait)
+	_, err = conn.StopUnitContext(context.Background(), serviceName, "replace", wait)
 	if err != nil {
 		return err
 	}
@@ -261,13 +445,13 @@ func (s *systemDAdapter) StopService(serviceName string) error {
 }
 
 func (s *systemDAdapter) ReloadService(serviceName string) error {
-	err := s.getConnection()
+	conn, err := s.getConnection()
 	if err != nil {
 		return err
 	}
 
 	wait := make(chan string)
-	_, err = s.conn.ReloadUnitContext(context.Background(), serviceName, "replace", wait)
+	_, err = conn.ReloadUnitContext(context.Background(), serviceName, "replace", wait)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,256 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/coreos/go-systemd/v22/dbus"
+)
+
+func TestFilterUnits(t *testing.T) {
+	units := []dbus.UnitStatus{
+		{Name: "nginx.service", ActiveState: "active", SubState: "running"},
+		{Name: "nginx-exporter.service", ActiveState: "failed", SubState: "failed"},
+		{Name: "sshd.service", ActiveState: "inactive", SubState: "dead"},
+		{Name: "docker.socket", ActiveState: "active", SubState: "listening"},
+	}
+	tests := []struct {
+		name     string
+		states   []string
+		patterns []string
+		want     []string
+	}{
+		{
+			name:     "exact name",
+			states:   states,
+			patterns: []string{"nginx.service"},
+			want:     []string{"nginx.service"},
+		},
+		{
+			name:     "glob pattern",
+			states:   states,
+			patterns: []string{"nginx*"},
+			want:     []string{"nginx.service", "nginx-exporter.service"},
+		},
+		{
+			name:     "several patterns",
+			states:   states,
+			patterns: []string{"sshd.service", "*.socket"},
+			want:     []string{"sshd.service", "docker.socket"},
+		},
+		{
+			name:     "active state",
+			states:   []string{"active"},
+			patterns: []string{"*"},
+			want:     []string{"nginx.service", "docker.socket"},
+		},
+		{
+			name:     "sub state",
+			states:   []string{"listening"},
+			patterns: []string{"*"},
+			want:     []string{"docker.socket"},
+		},
+		{
+			name:     "no match",
+			states:   states,
+			patterns: []string{"missing.service"},
+			want:     []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []string{}
+			for _, unit := range filterUnits(units, tt.states, tt.patterns) {
+				got = append(got, unit.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("filterUnits() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewAdapter(t *testing.T) {
+	tests := []struct {
+		name    string
+		adapter Adapter
+		wantBus Bus
+	}{
+		{name: "default", adapter: NewSystemDAdapter(), wantBus: PrivateBus},
+		{name: "private socket", adapter: NewPrivateSocketAdapter(), wantBus: PrivateBus},
+		{name: "system bus", adapter: NewSystemBusAdapter(), wantBus: SystemBus},
+		{name: "user bus", adapter: NewUserBusAdapter(), wantBus: UserBus},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.adapter.(*systemDAdapter)
+			if s.bus != tt.wantBus || s.connect == nil || s.conn != nil {
+				t.Errorf("adapter got bus %s, want %s connecting on first use", s.bus, tt.wantBus)
+			}
+		})
+	}
+
+	if _, err := NewConnAdapter(nil).GetVersion(); err == nil {
+		t.Errorf("GetVersion() without a connection expected an error")
+	}
+}
+
+func TestWithBus(t *testing.T) {
+	w := New([]string{"nginx"}, WithBus(UserBus)).(*watcher)
+	if w.bus != UserBus {
+		t.Errorf("WithBus() got = %s, want %s", w.bus, UserBus)
+	}
+	w.Stop()
+}
+
+func TestAdapterConcurrentUse(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	defer s.Close()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 150)
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			_, err := s.GetVersion()
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.ListUnitsByPattern(states, []string{"nginx.service"})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.GetPropertiesForUnit("nginx.service")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("concurrent call error = %v", err)
+		}
+	}
+	if bus.dials() != 1 {
+		t.Errorf("concurrent first calls opened %d connections, want 1", bus.dials())
+	}
+}
+
+func TestAdapterAlreadySubscribed(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	defer s.Close()
+
+	first := make(chan *dbus.PropertiesUpdate, 1)
+	if err := s.SubscribeToUnitProperties(first, make(chan error)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubscribeToUnitProperties(make(chan *dbus.PropertiesUpdate, 1), make(chan error)); !errors.Is(err, ErrAlreadySubscribed) {
+		t.Fatalf("second SubscribeToUnitProperties() error = %v, want ErrAlreadySubscribed", err)
+	}
+	if _, _, updateCh := bus.last().state(); updateCh != first {
+		t.Errorf("a rejected subscriber should not replace the first one")
+	}
+
+	if err := s.UnsubscribeFromUnitProperties(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SubscribeToUnitProperties(make(chan *dbus.PropertiesUpdate, 1), make(chan error)); err != nil {
+		t.Errorf("SubscribeToUnitProperties() after unsubscribing error = %v", err)
+	}
+}
+
+func TestAdapterReopenAfterClose(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+	if _, err := s.GetVersion(); err != nil {
+		t.Fatal(err)
+	}
+	first := bus.last()
+	s.Close()
+	s.Close()
+	if closed, _, _ := first.state(); !closed {
+		t.Errorf("Close() should close the connection")
+	}
+
+	if version, err := s.GetVersion(); err != nil || version != 250 {
+		t.Fatalf("GetVersion() after Close() got = %d, %v, want 250", version, err)
+	}
+	if closed, _, _ := bus.last().state(); bus.dials() != 2 || closed {
+		t.Errorf("a call after Close() should open a new connection, got %d dials", bus.dials())
+	}
+	s.Close()
+}
+
+func TestAdapterConcurrentClose(t *testing.T) {
+	bus := &fakeBus{}
+	s := newFakeBusAdapter(bus)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 20; i++ {
+		wg.Add(4)
+		go func() {
+			defer wg.Done()
+			_, err := s.ListUnitsByPattern(states, []string{"nginx.service"})
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- s.StartService("nginx.service")
+		}()
+		go func() {
+			defer wg.Done()
+			updateCh := make(chan *dbus.PropertiesUpdate, 1)
+			if err := s.SubscribeToUnitProperties(updateCh, make(chan error)); err != nil {
+				if errors.Is(err, ErrAlreadySubscribed) {
+					// another goroutine holds the subscriber
+					err = nil
+				}
+				errs <- err
+				return
+			}
+			errs <- s.UnsubscribeFromUnitProperties()
+		}()
+		go func() {
+			defer wg.Done()
+			s.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("call concurrent with Close() error = %v", err)
+		}
+	}
+
+	s.Close()
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	for i, conn := range bus.conns {
+		if closed, _, updateCh := conn.state(); !closed || updateCh != nil {
+			t.Errorf("connection %d should be closed without subscriber once the adapter is closed", i)
+		}
+	}
+}
@@ -15,7 +15,13 @@
 package libsysd
 
 import (
+	"context"
+	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/acceldata-io/goutils/netutils"
 )
 
 // Watcher implements a watch mechanism with poll and sub functions
@@ -25,6 +31,16 @@ type Watcher interface {
 	// Sub method is an event based method.
 	// If and only if there is an event occurred in the systemd managed services these will be captured by this method
 	Sub(opts ...WatcherOps)
+	// Events returns the channel where systemd events of this watcher are being pushed
+	Events() <-chan *SystemDEvent
+	// Errors returns the channel where any errors of this watcher are pushed
+	Errors() <-chan error
+	// Stop stops the running poll or sub loop, closes the systemd adapter
+	// and closes the events and errors channels.
+	// Stop blocks until the loop has returned and is safe to call more than once
+	Stop()
+	// Stats returns the counters of the events buffer
+	Stats() Stats
 }
 
 // TODO : Serializers to convert to certain output formats such as JSON, LineProtocol
@@ -32,20 +48,55 @@ type Watcher interface {
 type watcher struct {
 	watchList          []string
 	systemD            Adapter
+	ownsAdapter        bool
+	bus                Bus
 	metricsBufferLimit int64
+	overflowPolicy     OverflowPolicy
+	queue              *eventQueue
 	pollInterval       int64
-	hostnameMethod     string
+	hostnameMethods    []string
+	hostnameResolver   HostNameResolver
+	hostnamePrimed     chan struct{}
+	hostnameDeadline   time.Time
+	osHostName         string
+	events             chan *SystemDEvent
+	errs               chan error
+	ctx                context.Context
+	cancel             context.CancelFunc
+	done               chan struct{}
+	mutex              sync.Mutex
+	started            bool
+	stopped            bool
 }
 
 var (
 	// EventsOut is a channel where systemd events are being pushed
+	//
+	// Deprecated: EventsOut refers to the events channel of the most recently created watcher.
+	// Use Watcher.Events instead.
 	EventsOut = make(chan *SystemDEvent)
 	// eventsIn  = make(chan *SystemDEvent)
 
 	// ErrCh is a channel where any errors are pushed
+	//
+	// Deprecated: ErrCh refers to the errors channel of the most recently created watcher.
+	// Use Watcher.Errors instead.
 	ErrCh = make(chan error)
 )
 
+var defaultHostNameMethods = []string{"ENV", "RFQDN", "FQDN", "OS"}
+
+const defaultHostNameTTL = 5 * time.Minute
+
+// hostNamePrimingTimeout bounds how long the first events wait for the default resolver
+const hostNamePrimingTimeout = 3 * time.Second
+
+// HostNameResolver returns the hostname attached to every systemd event.
+// It is called for every event so it must not block, *netutils.Resolver implements it
+type HostNameResolver interface {
+	HostName() (string, error)
+}
+
 // WatcherOps sets optional parameters to a watcher
 type WatcherOps func(*watcher)
 
@@ -57,63 +108,281 @@ func WithPollInterval(interval int64) WatcherOps {
 }
 
 // WithMetricsBufferLimit set buffer limit for the number of systemd events
+// Events not yet consumed from Events are kept in this buffer, defaults to 1024
 func WithMetricsBufferLimit(limit int64) WatcherOps {
 	return func(w *watcher) {
 		w.metricsBufferLimit = limit
 	}
 }
 
+// WithOverflowPolicy sets what happens to new events when the events buffer is full
+// Defaults to OverflowBlock
+func WithOverflowPolicy(policy OverflowPolicy) WatcherOps {
+	return func(w *watcher) {
+		w.overflowPolicy = policy
+	}
+}
+
 // WithHostNameMethod sets the hostname method used to get the machine hostname
-// Valid methods are "RFQDN", "FQDN", "OS" and "CMD"
+// Valid methods are "ENV", "RFQDN", "FQDN", "OS" and "CMD"
 // Uses: github.com/acceldata-io/goutils/netutils
 func WithHostNameMethod(method string) WatcherOps {
 	return func(w *watcher) {
-		w.hostnameMethod = method
+		w.hostnameMethods = []string{method}
+	}
+}
+
+// WithHostNameMethods sets the hostname methods tried in order to get the machine hostname
+// Defaults to "ENV", "RFQDN", "FQDN" and "OS", ignored when WithHostNameResolver is used
+// Uses: github.com/acceldata-io/goutils/netutils
+func WithHostNameMethods(methods ...string) WatcherOps {
+	return func(w *watcher) {
+		w.hostnameMethods = methods
+	}
+}
+
+// WithHostNameResolver sets the resolver used to get the machine hostname.
+// By default a *netutils.Resolver caching the hostname methods result for 5 minutes is used,
+// it is resolved in the background when the watcher starts. The first events wait up to 3 seconds for it,
+// then the OS hostname is used until it is resolved
+func WithHostNameResolver(resolver HostNameResolver) WatcherOps {
+	return func(w *watcher) {
+		w.hostnameResolver = resolver
+	}
+}
+
+// WithAdapter sets the systemd adapter used by the watcher, a new private socket adapter is created by default.
+// The watcher only goes through the Adapter interface so it can be wrapped, e.g. for logging or rate limiting,
+// or shared between Poll watchers and at most one Sub watcher, a second Sub watcher stops with ErrAlreadySubscribed.
+// An adapter set with WithAdapter belongs to the caller and is not closed by Stop
+func WithAdapter(adapter Adapter) WatcherOps {
+	return func(w *watcher) {
+		w.systemD = adapter
+	}
+}
+
+// WithBus sets the bus of the systemd adapter created by the watcher, defaults to PrivateBus.
+// UserBus watches the units of the user instance of systemd, ignored when WithAdapter is used
+func WithBus(bus Bus) WatcherOps {
+	return func(w *watcher) {
+		w.bus = bus
+	}
+}
+
+// WithContext sets the parent context of the watcher.
+// The watcher stops the same way as with Stop when the context is done
+func WithContext(ctx context.Context) WatcherOps {
+	return func(w *watcher) {
+		w.ctx = ctx
 	}
 }
 
 // New returns a new watcher
 func New(watcherList []string, opts ...WatcherOps) Watcher {
-	sys := NewSystemDAdapter()
 	w := &watcher{
-		watchList: convertUnitType(watcherList),
-		systemD:   sys,
+		watchList:       convertUnitType(watcherList),
+		events:          make(chan *SystemDEvent),
+		errs:            make(chan error),
+		ctx:             context.Background(),
+		done:            make(chan struct{}),
+		hostnameMethods: defaultHostNameMethods,
 	}
 	for _, opt := range opts {
 		opt(w)
 	}
-	EventsOut = make(chan *SystemDEvent)
+	// keep the deprecated package level channels pointing at the latest watcher
+	EventsOut = w.events
+	ErrCh = w.errs
 	return w
 }
 
+// Sub starts the subscription loop, a watcher can only be started once
 func (w *watcher) Sub(opts ...WatcherOps) {
 	for _, opt := range opts {
 		opt(w)
 	}
-	EventsOut = make(chan *SystemDEvent)
-	go w.sub()
+	w.start(w.sub)
 }
 
+// Poll starts the poll loop, a watcher can only be started once
 func (w *watcher) Poll(opts ...WatcherOps) {
 	for _, opt := range opts {
 		opt(w)
 	}
-	EventsOut = make(chan *SystemDEvent)
-	go w.poll()
+	w.start(w.poll)
+}
+
+func (w *watcher) Stop() {
+	w.mutex.Lock()
+	if w.stopped {
+		w.mutex.Unlock()
+		<-w.done
+		return
+	}
+	w.stopped = true
+	if !w.started {
+		w.mutex.Unlock()
+		w.shutdown()
+		return
+	}
+	w.cancel()
+	w.mutex.Unlock()
+	<-w.done
 }
 
+func (w *watcher) start(loop func(ctx context.Context)) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.started || w.stopped {
+		return
+	}
+	w.started = true
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.cancel = cancel
+	w.queue = newEventQueue(w.metricsBufferLimit, w.overflowPolicy)
+	if w.systemD == nil {
+		w.systemD = NewAdapter(w.bus)
+		w.ownsAdapter = true
+	}
+	if w.hostnameResolver == nil {
+		w.hostnameResolver = netutils.NewResolver(w.hostnameMethods, 20, defaultHostNameTTL)
+		w.primeHostName(hostNamePrimingTimeout)
+	}
+	go func() {
+		defer w.shutdown()
+		defer cancel()
+		pumpDone := make(chan struct{})
+		go func() {
+			defer close(pumpDone)
+			w.pump(ctx)
+		}()
+		loop(ctx)
+		w.queue.close()
+		<-pumpDone
+	}()
+}
+
+// pump delivers the buffered events to the consumer
+func (w *watcher) pump(ctx context.Context) {
+	for {
+		e, ok := w.queue.pop(ctx)
+		if !ok {
+			return
+		}
+		select {
+		case w.events <- e:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *watcher) Stats() Stats {
+	w.mutex.Lock()
+	queue := w.queue
+	w.mutex.Unlock()
+	if queue == nil {
+		return Stats{}
+	}
+	return queue.stats()
+}
+
+// shutdown releases the adapter created by the watcher and closes the channels once the loop is no longer sending on them
+func (w *watcher) shutdown() {
+	if w.ownsAdapter {
+		w.systemD.Close()
+	}
+	close(w.events)
+	close(w.errs)
+	close(w.done)
+}
+
+func (w *watcher) sendEvent(ctx context.Context, e *SystemDEvent) bool {
+	return w.queue.push(ctx, e)
+}
+
+func (w *watcher) sendError(ctx context.Context, err error) bool {
+	select {
+	case w.errs <- err:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *watcher) Events() <-chan *SystemDEvent {
+	return w.events
+}
+
+func (w *watcher) Errors() <-chan error {
+	return w.errs
+}
+
+// primeHostName resolves the hostname off the event path, the first lookup may wait on slow DNS or commands.
+// Events wait for it until the timeout so a host does not report under its OS hostname first
+func (w *watcher) primeHostName(timeout time.Duration) {
+	primed := make(chan struct{})
+	w.hostnamePrimed = primed
+	w.hostnameDeadline = time.Now().Add(timeout)
+	w.osHostName = osHostName()
+	go func() {
+		defer close(primed)
+		w.hostnameResolver.HostName()
+	}()
+}
+
+// hostName returns the resolved hostname, the OS hostname if the default resolver is not primed in time
+// and "localhost" if it cannot be resolved
+func (w *watcher) hostName() string {
+	if w.hostnamePrimed != nil {
+		select {
+		case <-w.hostnamePrimed:
+		default:
+			timer := time.NewTimer(time.Until(w.hostnameDeadline))
+			defer timer.Stop()
+			select {
+			case <-w.hostnamePrimed:
+			case <-timer.C:
+				return w.osHostName
+			}
+		}
+	}
+	hostName, err := w.hostnameResolver.HostName()
+	if err != nil || hostName == "" {
+		return "localhost"
+	}
+	return hostName
+}
+
+func osHostName() string {
+	hostName, err := os.Hostname()
+	if err != nil || hostName == "" {
+		return "localhost"
+	}
+	return hostName
+}
+
+var unitTypes = []string{"service", "socket", "device", "mount", "automount", "swap", "target", "path", "timer", "slice", "scope"}
+
+// convertUnitType adds the ".service" suffix to the names without a unit type and removes the duplicates
 func convertUnitType(unitList []string) []string {
 	properUnitName := []string{}
 	for _, u := range unitList {
+		if !hasUnitType(u) {
+			u += ".service"
+		}
 		if !stringInSlice(u, properUnitName) {
-			if !strings.ContainsRune(u, '.') {
-				properUnitName = append(properUnitName, u+".service")
-			}
+			properUnitName = append(properUnitName, u)
 		}
 	}
 	return properUnitName
 }
 
+func hasUnitType(unit string) bool {
+	dot := strings.LastIndexByte(unit, '.')
+	return dot >= 0 && stringInSlice(unit[dot+1:], unitTypes)
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
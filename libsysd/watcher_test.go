@@ -0,0 +1,511 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package libsysd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acceldata-io/goutils/libsysd/libsysdtest"
+	"github.com/coreos/go-systemd/v22/dbus"
+	godbus "github.com/godbus/dbus/v5"
+)
+
+var _ Adapter = (*libsysdtest.Adapter)(nil)
+
+type staticHostName string
+
+func (h staticHostName) HostName() (string, error) {
+	return string(h), nil
+}
+
+// blockingHostName waits for release before returning its hostname
+type blockingHostName struct {
+	name    string
+	release chan struct{}
+}
+
+func (h *blockingHostName) HostName() (string, error) {
+	<-h.release
+	return h.name, nil
+}
+
+func newTestWatcher(adapter Adapter, units []string, opts ...WatcherOps) Watcher {
+	opts = append([]WatcherOps{WithAdapter(adapter), WithHostNameResolver(staticHostName("node-1"))}, opts...)
+	return New(units, opts...)
+}
+
+// countingAdapter is a decorator counting the calls going through the Adapter interface
+type countingAdapter struct {
+	Adapter
+	mutex sync.Mutex
+	calls map[string]int
+}
+
+func (a *countingAdapter) count(method string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.calls[method]++
+}
+
+func (a *countingAdapter) ListUnitsByPattern(states, patterns []string) ([]dbus.UnitStatus, error) {
+	a.count("ListUnitsByPattern")
+	return a.Adapter.ListUnitsByPattern(states, patterns)
+}
+
+func (a *countingAdapter) GetPropertiesForUnit(unit string) (map[string]interface{}, error) {
+	a.count("GetPropertiesForUnit")
+	return a.Adapter.GetPropertiesForUnit(unit)
+}
+
+func nextEvent(t *testing.T, w Watcher) *SystemDEvent {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		return e
+	case err := <-w.Errors():
+		t.Fatalf("unexpected error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no event received")
+	}
+	return nil
+}
+
+func nextError(t *testing.T, w Watcher) error {
+	t.Helper()
+	select {
+	case err := <-w.Errors():
+		return err
+	case e := <-w.Events():
+		t.Fatalf("unexpected event: %+v", e)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no error received")
+	}
+	return nil
+}
+
+func TestConvertUnitType(t *testing.T) {
+	tests := []struct {
+		name  string
+		units []string
+		want  []string
+	}{
+		{
+			name:  "service suffix added",
+			units: []string{"nginx", "sshd"},
+			want:  []string{"nginx.service", "sshd.service"},
+		},
+		{
+			name:  "unit types kept",
+			units: []string{"nginx.service", "docker.socket", "logrotate.timer", "multi-user.target"},
+			want:  []string{"nginx.service", "docker.socket", "logrotate.timer", "multi-user.target"},
+		},
+		{
+			name:  "dotted names",
+			units: []string{"dbus-org.freedesktop.resolve1", "user@1000.service"},
+			want:  []string{"dbus-org.freedesktop.resolve1.service", "user@1000.service"},
+		},
+		{
+			name:  "duplicates removed",
+			units: []string{"nginx", "nginx", "nginx.service"},
+			want:  []string{"nginx.service"},
+		},
+		{
+			name:  "empty",
+			units: nil,
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertUnitType(tt.units); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("convertUnitType() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHostNamePriming(t *testing.T) {
+	osName, err := os.Hostname()
+	if err != nil || osName == "" {
+		t.Skipf("cannot get the OS hostname: %v", err)
+	}
+
+	t.Run("primed in time", func(t *testing.T) {
+		resolver := &blockingHostName{name: "node-1.example.com", release: make(chan struct{})}
+		w := &watcher{hostnameResolver: resolver}
+		w.primeHostName(5 * time.Second)
+		time.AfterFunc(50*time.Millisecond, func() { close(resolver.release) })
+		if got := w.hostName(); got != resolver.name {
+			t.Errorf("hostName() got = %s, want %s without the OS hostname first", got, resolver.name)
+		}
+	})
+	t.Run("priming timeout", func(t *testing.T) {
+		resolver := &blockingHostName{name: "node-1.example.com", release: make(chan struct{})}
+		w := &watcher{hostnameResolver: resolver}
+		w.primeHostName(50 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			if got := w.hostName(); got != osName {
+				t.Errorf("hostName() while priming got = %s, want the OS hostname %s", got, osName)
+			}
+		}
+		close(resolver.release)
+		<-w.hostnamePrimed
+		if got := w.hostName(); got != resolver.name {
+			t.Errorf("hostName() once primed got = %s, want %s", got, resolver.name)
+		}
+	})
+}
+
+func TestPoll(t *testing.T) {
+	adapter := libsysdtest.NewAdapter().
+		AddUnit("nginx.service", map[string]interface{}{"MainPID": uint32(42)}).
+		AddUnit("sshd.service", nil)
+	w := newTestWatcher(adapter, []string{"nginx", "sshd"})
+	w.Poll(WithPollInterval(1))
+
+	for _, want := range []string{"nginx.service", "sshd.service"} {
+		e := nextEvent(t, w)
+		if e.UnitName != want || e.Hostname != "node-1" || e.PropertyUpdate["ActiveState"] != "active" {
+			t.Errorf("Poll() got = %+v, want an active %s event", e, want)
+		}
+	}
+	if err := adapter.SetState("nginx.service", "failed", "failed"); err != nil {
+		t.Fatal(err)
+	}
+	if e := nextEvent(t, w); e.UnitName != "nginx.service" || e.PropertyUpdate["ActiveState"] != "failed" {
+		t.Errorf("Poll() after the transition got = %+v, want a failed nginx.service event", e)
+	}
+
+	w.Stop()
+	if adapter.Closed() {
+		t.Errorf("Stop() should not close an adapter set with WithAdapter")
+	}
+}
+
+func TestWatchersChannels(t *testing.T) {
+	nginx := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+	sshd := libsysdtest.NewAdapter().AddUnit("sshd.service", nil)
+	first := newTestWatcher(nginx, []string{"nginx"})
+	second := newTestWatcher(sshd, []string{"sshd"})
+	defer first.Stop()
+	defer second.Stop()
+
+	if EventsOut != second.Events() || ErrCh != second.Errors() {
+		t.Errorf("EventsOut and ErrCh should point at the channels of the latest watcher")
+	}
+	first.Poll(WithPollInterval(1))
+	second.Poll(WithPollInterval(1))
+	for i := 0; i < 2; i++ {
+		if e := nextEvent(t, first); e.UnitName != "nginx.service" {
+			t.Errorf("first watcher got = %+v, want only nginx.service events", e)
+		}
+		if e := nextEvent(t, second); e.UnitName != "sshd.service" {
+			t.Errorf("second watcher got = %+v, want only sshd.service events", e)
+		}
+	}
+}
+
+func TestStop(t *testing.T) {
+	t.Run("context canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+		w := newTestWatcher(adapter, []string{"nginx"}, WithContext(ctx))
+		w.Sub()
+		if err := adapter.WaitSubscribed(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		assertClosed(t, w)
+		if adapter.Subscribed() {
+			t.Errorf("canceling the context should unsubscribe")
+		}
+	})
+	t.Run("before start", func(t *testing.T) {
+		adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+		w := newTestWatcher(adapter, []string{"nginx"})
+		w.Stop()
+		assertClosed(t, w)
+		w.Poll(WithPollInterval(1))
+		if adapter.Calls("ListUnitsByPattern") != 0 {
+			t.Errorf("Poll() after Stop() should not start the loop")
+		}
+	})
+	t.Run("twice", func(t *testing.T) {
+		w := newTestWatcher(libsysdtest.NewAdapter().AddUnit("nginx.service", nil), []string{"nginx"})
+		w.Poll(WithPollInterval(1))
+		w.Stop()
+		w.Stop()
+		assertClosed(t, w)
+	})
+}
+
+// assertClosed drains the events and errors channels of the watcher until they are closed
+func assertClosed(t *testing.T, w Watcher) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	events, errs := w.Events(), w.Errors()
+	for events != nil || errs != nil {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+			}
+		case _, ok := <-errs:
+			if !ok {
+				errs = nil
+			}
+		case <-timeout:
+			t.Fatalf("the events and errors channels were not closed")
+		}
+	}
+}
+
+func TestWithAdapterDecorator(t *testing.T) {
+	fake := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+	adapter := &countingAdapter{Adapter: fake, calls: map[string]int{}}
+	w := newTestWatcher(adapter, []string{"nginx"})
+	w.Poll(WithPollInterval(1))
+	if e := nextEvent(t, w); e.UnitName != "nginx.service" {
+		t.Errorf("Poll() got = %+v, want a nginx.service event", e)
+	}
+	w.Stop()
+
+	adapter.mutex.Lock()
+	defer adapter.mutex.Unlock()
+	for _, method := range []string{"ListUnitsByPattern", "GetPropertiesForUnit"} {
+		if adapter.calls[method] != fake.Calls(method) || adapter.calls[method] == 0 {
+			t.Errorf("%s went %d times through the decorator and %d times to the adapter", method, adapter.calls[method], fake.Calls(method))
+		}
+	}
+}
+
+func TestPollErrors(t *testing.T) {
+	injected := errors.New("bus unavailable")
+	tests := []struct {
+		name    string
+		units   []string
+		inject  string
+		wantErr string
+	}{
+		{
+			name:    "no units",
+			wantErr: "no systemd services were provided",
+		},
+		{
+			name:    "unit not found",
+			units:   []string{"missing"},
+			wantErr: "missing.service unit listed cannot be found",
+		},
+		{
+			name:    "list error",
+			units:   []string{"nginx"},
+			inject:  "ListUnitsByPattern",
+			wantErr: injected.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+			if tt.inject != "" {
+				adapter.InjectError(tt.inject, injected)
+			}
+			w := newTestWatcher(adapter, tt.units)
+			w.Poll(WithPollInterval(1))
+			if err := nextError(t, w); !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Poll() error = %v, want %v", err, tt.wantErr)
+			}
+			w.Stop()
+		})
+	}
+}
+
+func TestPollPropertiesError(t *testing.T) {
+	injected := errors.New("no such property")
+	adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+	adapter.InjectError("GetPropertiesForUnit", injected)
+	w := newTestWatcher(adapter, []string{"nginx"})
+	w.Poll(WithPollInterval(1))
+	defer w.Stop()
+
+	if err := nextError(t, w); !errors.Is(err, injected) {
+		t.Errorf("Poll() error = %v, want %v", err, injected)
+	}
+	// the event of the unit is still sent, without properties
+	if e := nextEvent(t, w); e.UnitName != "nginx.service" || e.PropertyUpdate != nil {
+		t.Errorf("Poll() got = %+v, want an empty nginx.service event", e)
+	}
+}
+
+func TestSub(t *testing.T) {
+	adapter := libsysdtest.NewAdapter().
+		AddUnit("nginx.service", nil).
+		AddUnit("sshd.service", nil)
+	w := newTestWatcher(adapter, []string{"nginx"})
+	w.Sub()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := adapter.WaitSubscribed(ctx); err != nil {
+		t.Fatalf("Sub() did not subscribe: %v", err)
+	}
+
+	// sshd.service is not watched, its transition is left out
+	if err := adapter.SetState("sshd.service", "inactive", "dead"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.StopService("nginx.service"); err != nil {
+		t.Fatal(err)
+	}
+	e := nextEvent(t, w)
+	want := map[string]interface{}{"ActiveState": "inactive", "SubState": "dead"}
+	if e.UnitName != "nginx.service" || e.Hostname != "node-1" || !reflect.DeepEqual(e.PropertyUpdate, want) {
+		t.Errorf("Sub() got = %+v, want nginx.service %v", e, want)
+	}
+
+	injected := errors.New("signal lost")
+	go adapter.EmitError(injected)
+	if err := nextError(t, w); !errors.Is(err, injected) {
+		t.Errorf("Sub() error = %v, want %v", err, injected)
+	}
+
+	w.Stop()
+	if adapter.Subscribed() || adapter.Closed() {
+		t.Errorf("Stop() should unsubscribe and leave the adapter open")
+	}
+	if _, ok := <-w.Events(); ok {
+		t.Errorf("Events() should be closed once stopped")
+	}
+}
+
+func TestSubDroppedUpdates(t *testing.T) {
+	adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+	w := newTestWatcher(adapter, []string{"nginx"}, WithMetricsBufferLimit(1))
+	w.Sub()
+	defer w.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := adapter.WaitSubscribed(ctx); err != nil {
+		t.Fatalf("Sub() did not subscribe: %v", err)
+	}
+	// nothing is consumed, so the properties channel fills up and go-systemd drops the next update
+	update := &dbus.PropertiesUpdate{UnitName: "nginx.service", Changed: map[string]godbus.Variant{"SubState": godbus.MakeVariant("running")}}
+	dropped := false
+	for i := 0; i < 1000 && !dropped; i++ {
+		dropped = !adapter.Emit(update)
+	}
+	if !dropped {
+		t.Fatalf("Emit() never dropped an update")
+	}
+	for w.Stats().Dropped == 0 {
+		select {
+		case <-w.Events():
+		case <-ctx.Done():
+			t.Fatalf("the update dropped by go-systemd was not counted")
+		}
+	}
+}
+
+func TestFakeAdapterAlreadySubscribed(t *testing.T) {
+	adapter := libsysdtest.NewAdapter()
+	first, second := make(chan *dbus.PropertiesUpdate, 1), make(chan *dbus.PropertiesUpdate, 1)
+	if err := adapter.SubscribeToUnitProperties(first, make(chan error)); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.SubscribeToUnitProperties(second, make(chan error)); !errors.Is(err, ErrAlreadySubscribed) {
+		t.Fatalf("second SubscribeToUnitProperties() error = %v, want ErrAlreadySubscribed", err)
+	}
+	if !adapter.Emit(&dbus.PropertiesUpdate{UnitName: "nginx.service"}) || len(first) != 1 || len(second) != 0 {
+		t.Errorf("a rejected subscriber should not replace the first one")
+	}
+
+	for _, release := range []func() error{adapter.UnsubscribeFromUnitProperties, func() error { adapter.Close(); return nil }} {
+		if err := release(); err != nil {
+			t.Fatal(err)
+		}
+		if err := adapter.SubscribeToUnitProperties(second, make(chan error)); err != nil {
+			t.Errorf("SubscribeToUnitProperties() once released error = %v", err)
+		}
+		if err := adapter.WaitSubscribed(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSubSharedAdapter(t *testing.T) {
+	adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+	first := newTestWatcher(adapter, []string{"nginx"})
+	first.Sub()
+	defer first.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := adapter.WaitSubscribed(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	second := newTestWatcher(adapter, []string{"nginx"})
+	second.Sub()
+	defer second.Stop()
+	if err := nextError(t, second); !errors.Is(err, ErrAlreadySubscribed) {
+		t.Errorf("second Sub() error = %v, want ErrAlreadySubscribed", err)
+	}
+}
+
+func TestSubErrors(t *testing.T) {
+	injected := errors.New("bus unavailable")
+	tests := []struct {
+		name    string
+		units   []string
+		inject  string
+		wantErr string
+	}{
+		{
+			name:    "no units",
+			wantErr: "no systemd services were provided",
+		},
+		{
+			name:    "unit not found",
+			units:   []string{"missing"},
+			wantErr: "missing.service unit listed cannot be found",
+		},
+		{
+			name:    "subscribe error",
+			units:   []string{"nginx"},
+			inject:  "SubscribeToUnitProperties",
+			wantErr: injected.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adapter := libsysdtest.NewAdapter().AddUnit("nginx.service", nil)
+			if tt.inject != "" {
+				adapter.InjectError(tt.inject, injected)
+			}
+			w := newTestWatcher(adapter, tt.units)
+			w.Sub()
+			if err := nextError(t, w); !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Sub() error = %v, want %v", err, tt.wantErr)
+			}
+			w.Stop()
+		})
+	}
+}
@@ -4,5 +4,5 @@ go 1.19
 
 require (
 	github.com/Showmax/go-fqdn v1.0.0
-	github.com/acceldata-io/goutils/shellutils v0.0.0-20221123071023-3339e0dfbdb0
+	github.com/acceldata-io/goutils/shellutils v0.1.0
 )
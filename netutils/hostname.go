@@ -29,12 +29,61 @@ import (
 
 const hostnameBinPath = "/bin/hostname"
 
+// DefaultHostNameEnv is the environment variable read by the "ENV" method unless WithHostNameEnv is used
+const DefaultHostNameEnv = "PULSE_HOSTNAME"
+
+// DefaultDNSTimeout is the timeout of every DNS lookup of the "RFQDN" method unless WithDNSTimeout is used
+const DefaultDNSTimeout = 5 * time.Second
+
+type hostNameConfig struct {
+	envName       string
+	addressFamily AddressFamily
+	strategy      SelectionStrategy
+	domainSuffix  string
+	ctx           context.Context
+	dnsResolver   DNSResolver
+	dnsTimeout    time.Duration
+}
+
+func newHostNameConfig(opts []HostNameOps) *hostNameConfig {
+	config := &hostNameConfig{
+		envName:     DefaultHostNameEnv,
+		ctx:         context.Background(),
+		dnsResolver: net.DefaultResolver,
+		dnsTimeout:  DefaultDNSTimeout,
+	}
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
+// HostNameOps sets optional parameters used by the hostname methods
+type HostNameOps func(*hostNameConfig)
+
+// WithHostNameEnv sets the environment variable read by the "ENV" method
+func WithHostNameEnv(name string) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.envName = name
+	}
+}
+
+// WithContext sets the context of the DNS lookups
+func WithContext(ctx context.Context) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.ctx = ctx
+	}
+}
+
 // GetHostName fetches the hostname of the machine using various methods
-// Supported methods are "RFQDN", "FQDN", "OS" and "CMD"
-func GetHostName(hostNameCommand string, cmdTimeout int) (string, error) {
+// Supported methods are "ENV", "RFQDN", "FQDN", "OS" and "CMD"
+func GetHostName(hostNameCommand string, cmdTimeout int, opts ...HostNameOps) (string, error) {
+	config := newHostNameConfig(opts)
 	switch hostNameCommand {
+	case "ENV":
+		return getEnvHostName(config.envName)
 	case "RFQDN":
-		hostName, err := getReverseFQDN()
+		hostName, err := getReverseFQDN(config)
 		if err != nil {
 			return "", err
 		}
@@ -55,45 +104,111 @@ func GetHostName(hostNameCommand string, cmdTimeout int) (string, error) {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cmdTimeout)*time.Second)
 		defer cancel()
 
-		cmdToRun := cmd.New(ctx, "", []string{})
-		cmdToRun.WithExpression("bash", hostnameBinPath+" -f")
-		cmdToRun.Run()
+		cmdToRun := cmd.New(ctx, hostnameBinPath, []string{"-f"})
+		if _, err := cmdToRun.Run(); err != nil {
+			return "", err
+		}
 		if cmdToRun.Status.ExitCode == 0 {
 			if strings.TrimSpace(cmdToRun.Status.StdOut) == "" {
 				return "", fmt.Errorf("getting empty response in CMD Hostname Method, Because: %s", cmdToRun.Status.StdErr)
 			}
 			return strings.TrimSpace(cmdToRun.Status.StdOut), nil
 		}
-		return "", fmt.Errorf(cmdToRun.Status.StdErr)
+		return "", fmt.Errorf("%s exited with code %d: %s", hostnameBinPath, cmdToRun.Status.ExitCode, strings.TrimSpace(cmdToRun.Status.StdErr))
 	default:
 		return "", fmt.Errorf("unsupported method %q specified", hostNameCommand)
 	}
 }
 
-func getReverseFQDN() (string, error) {
-	hostname, err := os.Hostname()
-	if err != nil {
-		return "", err
+// HostNameResult is the outcome of GetHostNameWithFallback
+type HostNameResult struct {
+	HostName string        // HostName returned by the method that succeeded
+	Method   string        // Method that succeeded
+	Errors   []MethodError // Errors of the methods tried before, in order
+}
+
+// MethodError is the error returned by a single hostname method
+type MethodError struct {
+	Method string
+	Err    error
+}
+
+func (e MethodError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Method, e.Err)
+}
+
+func (e MethodError) Unwrap() error {
+	return e.Err
+}
+
+// FallbackError is returned by GetHostNameWithFallback when every method failed
+type FallbackError struct {
+	Errors []MethodError
+}
+
+func (e *FallbackError) Error() string {
+	if len(e.Errors) == 0 {
+		return "no hostname method was provided"
+	}
+	msgs := make([]string, 0, len(e.Errors))
+	for _, methodErr := range e.Errors {
+		msgs = append(msgs, methodErr.Error())
+	}
+	return "all hostname methods failed: " + strings.Join(msgs, "; ")
+}
+
+// GetHostNameWithFallback tries the hostname methods in order and returns the first hostname found.
+// The result tells which method succeeded and why the methods before it failed
+func GetHostNameWithFallback(methods []string, cmdTimeout int, opts ...HostNameOps) (HostNameResult, error) {
+	result := HostNameResult{}
+	for _, method := range methods {
+		hostName, err := GetHostName(method, cmdTimeout, opts...)
+		if err == nil && hostName == "" {
+			err = fmt.Errorf("empty hostname")
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, MethodError{Method: method, Err: err})
+			continue
+		}
+		result.HostName = hostName
+		result.Method = method
+		return result, nil
 	}
+	return result, &FallbackError{Errors: result.Errors}
+}
 
-	address, err := net.LookupIP(hostname)
-	if err != nil {
-		return hostname, err
+func getEnvHostName(envName string) (string, error) {
+	hostName, ok := os.LookupEnv(envName)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", envName)
 	}
+	hostName = strings.TrimSuffix(strings.TrimSpace(hostName), ".")
+	if err := ValidateHostName(hostName); err != nil {
+		return "", fmt.Errorf("environment variable %s: %w", envName, err)
+	}
+	return hostName, nil
+}
 
-	for _, addr := range address {
-		if ipv4 := addr.To4(); ipv4 != nil {
-			ip, err := ipv4.MarshalText()
-			if err != nil {
-				return hostname, err
-			}
-			hosts, err := net.LookupAddr(string(ip))
-			if err != nil || len(hosts) == 0 {
-				return hostname, err
+// ValidateHostName checks that the name is a legal DNS host name (RFC 1123)
+func ValidateHostName(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty hostname")
+	}
+	if len(name) > 253 {
+		return fmt.Errorf("hostname %q is longer than 253 characters", name)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return fmt.Errorf("hostname %q has a label that is empty or longer than 63 characters", name)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("hostname %q has a label that starts or ends with a hyphen", name)
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return fmt.Errorf("hostname %q contains the invalid character %q", name, r)
 			}
-			fqdnHostname := hosts[0]
-			return strings.TrimSuffix(fqdnHostname, "."), nil // return fqdn without trailing dot
 		}
 	}
-	return hostname, nil
+	return nil
 }
@@ -55,3 +55,65 @@ func TestGetHostName(t *testing.T) {
 		})
 	}
 }
+
+func TestGetHostNameEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		set     bool
+		want    string
+		wantErr bool
+	}{
+		{name: "valid", value: "node-1.example.com", set: true, want: "node-1.example.com"},
+		{name: "trailing dot and spaces", value: " node-1.example.com.\n", set: true, want: "node-1.example.com"},
+		{name: "not set", set: false, wantErr: true},
+		{name: "empty", value: "", set: true, wantErr: true},
+		{name: "invalid character", value: "node_1.example.com", set: true, wantErr: true},
+		{name: "leading hyphen", value: "-node.example.com", set: true, wantErr: true},
+		{name: "empty label", value: "node..example.com", set: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.set {
+				t.Setenv("NODE_HOSTNAME", tt.value)
+			}
+			got, err := GetHostName("ENV", 0, WithHostNameEnv("NODE_HOSTNAME"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetHostName() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("GetHostName() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHostNameWithFallback(t *testing.T) {
+	t.Setenv("NODE_HOSTNAME", "node-1.example.com")
+	tests := []struct {
+		name       string
+		methods    []string
+		want       string
+		wantMethod string
+		wantErrs   int
+		wantErr    bool
+	}{
+		{name: "first method", methods: []string{"ENV", "OS"}, want: "node-1.example.com", wantMethod: "ENV"},
+		{name: "falls back", methods: []string{"UNKNOWN", "ENV"}, want: "node-1.example.com", wantMethod: "ENV", wantErrs: 1},
+		{name: "all fail", methods: []string{"UNKNOWN", "OTHER"}, wantErrs: 2, wantErr: true},
+		{name: "no methods", methods: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetHostNameWithFallback(tt.methods, 0, WithHostNameEnv("NODE_HOSTNAME"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetHostNameWithFallback() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.HostName != tt.want || got.Method != tt.wantMethod || len(got.Errors) != tt.wantErrs {
+				t.Errorf("GetHostNameWithFallback() got = %+v, want %v from %v with %d errors", got, tt.want, tt.wantMethod, tt.wantErrs)
+			}
+		})
+	}
+}
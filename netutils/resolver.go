@@ -0,0 +1,96 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutils
+
+import (
+	"sync"
+	"time"
+)
+
+// Resolver caches the hostname found with GetHostNameWithFallback.
+// Once the TTL expires the cached hostname is still returned while it is refreshed in the background,
+// so only the very first lookup waits for the hostname methods
+type Resolver struct {
+	methods    []string
+	cmdTimeout int
+	ttl        time.Duration
+	opts       []HostNameOps
+
+	mutex      sync.Mutex
+	resolved   bool
+	succeeded  bool
+	refreshing bool
+	expires    time.Time
+	result     HostNameResult
+	err        error
+
+	// refreshMutex serializes the calls to the hostname methods
+	refreshMutex sync.Mutex
+}
+
+// NewResolver returns a new hostname resolver trying the methods in order, see GetHostNameWithFallback.
+// A TTL of zero or less caches the hostname forever
+func NewResolver(methods []string, cmdTimeout int, ttl time.Duration, opts ...HostNameOps) *Resolver {
+	return &Resolver{
+		methods:    methods,
+		cmdTimeout: cmdTimeout,
+		ttl:        ttl,
+		opts:       opts,
+	}
+}
+
+// HostName returns the cached hostname, resolving it on the first call
+func (r *Resolver) HostName() (string, error) {
+	result, err := r.Result()
+	return result.HostName, err
+}
+
+// Result returns the cached result of GetHostNameWithFallback, resolving it on the first call.
+// When a refresh fails the last successful result is kept
+func (r *Resolver) Result() (HostNameResult, error) {
+	r.mutex.Lock()
+	if !r.resolved {
+		r.mutex.Unlock()
+		return r.Refresh()
+	}
+	if r.ttl > 0 && !r.refreshing && time.Now().After(r.expires) {
+		r.refreshing = true
+		go r.Refresh()
+	}
+	result, err := r.result, r.err
+	r.mutex.Unlock()
+	return result, err
+}
+
+// Refresh resolves the hostname right away and updates the cache
+func (r *Resolver) Refresh() (HostNameResult, error) {
+	r.refreshMutex.Lock()
+	defer r.refreshMutex.Unlock()
+
+	result, err := GetHostNameWithFallback(r.methods, r.cmdTimeout, r.opts...)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err == nil || !r.succeeded {
+		r.result, r.err = result, err
+	}
+	if err == nil {
+		r.succeeded = true
+	}
+	r.resolved = true
+	r.refreshing = false
+	r.expires = time.Now().Add(r.ttl)
+	return r.result, r.err
+}
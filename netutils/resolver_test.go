@@ -0,0 +1,61 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutils
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestResolver(t *testing.T) {
+	t.Setenv("NODE_HOSTNAME", "node-1.example.com")
+	r := NewResolver([]string{"ENV"}, 0, 50*time.Millisecond, WithHostNameEnv("NODE_HOSTNAME"))
+
+	got, err := r.HostName()
+	if err != nil || got != "node-1.example.com" {
+		t.Fatalf("HostName() got = %v, %v, want node-1.example.com", got, err)
+	}
+
+	t.Setenv("NODE_HOSTNAME", "node-2.example.com")
+	if got, _ := r.HostName(); got != "node-1.example.com" {
+		t.Errorf("HostName() before the TTL got = %v, want the cached node-1.example.com", got)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if got, _ := r.HostName(); got != "node-1.example.com" {
+		t.Errorf("HostName() after the TTL got = %v, want the stale node-1.example.com while refreshing", got)
+	}
+	deadline := time.Now().Add(time.Second)
+	for got != "node-2.example.com" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		got, _ = r.HostName()
+	}
+	if got != "node-2.example.com" {
+		t.Errorf("HostName() after the refresh got = %v, want node-2.example.com", got)
+	}
+
+	_ = os.Unsetenv("NODE_HOSTNAME")
+	if got, err := r.Refresh(); err != nil || got.HostName != "node-2.example.com" {
+		t.Errorf("Refresh() with a failing method got = %v, %v, want the last successful node-2.example.com", got, err)
+	}
+}
+
+func TestResolverError(t *testing.T) {
+	r := NewResolver([]string{"UNKNOWN"}, 0, time.Minute)
+	if _, err := r.HostName(); err == nil {
+		t.Errorf("HostName() expected an error when every method fails")
+	}
+}
@@ -0,0 +1,325 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// DNSResolver looks up the addresses of a host and the names of an address, *net.Resolver implements it
+type DNSResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+// AddressFamily sets which addresses of the machine are reverse looked up by the "RFQDN" method, and in which order
+type AddressFamily int
+
+const (
+	// IPv4First looks up the IPv4 addresses before the IPv6 ones
+	IPv4First AddressFamily = iota
+	// IPv6First looks up the IPv6 addresses before the IPv4 ones
+	IPv6First
+	// IPv4Only looks up the IPv4 addresses only
+	IPv4Only
+	// IPv6Only looks up the IPv6 addresses only
+	IPv6Only
+)
+
+// SelectionStrategy decides which reverse DNS candidate is picked by the "RFQDN" method
+type SelectionStrategy int
+
+const (
+	// SelectFirst picks the first PTR record of the first address that resolves
+	SelectFirst SelectionStrategy = iota
+	// SelectForwardConfirmed picks the first candidate whose name resolves back to its address
+	SelectForwardConfirmed
+	// SelectLongest picks the longest candidate name
+	SelectLongest
+	// SelectDomainSuffix picks the first candidate name ending with the domain suffix set with WithDomainSuffix
+	SelectDomainSuffix
+)
+
+func (s SelectionStrategy) String() string {
+	switch s {
+	case SelectFirst:
+		return "first"
+	case SelectForwardConfirmed:
+		return "forward-confirmed"
+	case SelectLongest:
+		return "longest"
+	case SelectDomainSuffix:
+		return "domain-suffix"
+	default:
+		return fmt.Sprintf("SelectionStrategy(%d)", int(s))
+	}
+}
+
+// WithAddressFamily sets the address family preference of the "RFQDN" method, defaults to IPv4First
+func WithAddressFamily(family AddressFamily) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.addressFamily = family
+	}
+}
+
+// WithDNSResolver sets the resolver used by the "RFQDN" method, defaults to net.DefaultResolver
+func WithDNSResolver(resolver DNSResolver) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.dnsResolver = resolver
+	}
+}
+
+// WithDNSTimeout sets the timeout of every DNS lookup of the "RFQDN" method, defaults to DefaultDNSTimeout.
+// A timeout of zero or less only bounds the lookups by the context set with WithContext
+func WithDNSTimeout(timeout time.Duration) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.dnsTimeout = timeout
+	}
+}
+
+// WithSelectionStrategy sets how the "RFQDN" method picks a reverse DNS candidate, defaults to SelectFirst
+func WithSelectionStrategy(strategy SelectionStrategy) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.strategy = strategy
+	}
+}
+
+// WithDomainSuffix sets the domain suffix used by the SelectDomainSuffix strategy
+func WithDomainSuffix(suffix string) HostNameOps {
+	return func(c *hostNameConfig) {
+		c.domainSuffix = strings.Trim(suffix, ".")
+	}
+}
+
+// ReverseCandidate is a name found by reverse looking up an address of the machine
+type ReverseCandidate struct {
+	Address          net.IP
+	Name             string // Name without the trailing dot
+	ForwardConfirmed bool   // ForwardConfirmed is only checked by the SelectForwardConfirmed strategy
+}
+
+// AddressError is the error returned while looking up a single address
+type AddressError struct {
+	Address net.IP
+	Err     error
+}
+
+func (e AddressError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Address, e.Err)
+}
+
+func (e AddressError) Unwrap() error {
+	return e.Err
+}
+
+// ReverseLookupResult explains which reverse DNS candidate was chosen and why
+type ReverseLookupResult struct {
+	HostName   string             // HostName chosen, the OS hostname when no address could be looked up
+	Chosen     *ReverseCandidate  // Chosen candidate, nil when none was chosen
+	Reason     string             // Reason why the hostname was chosen
+	Candidates []ReverseCandidate // Candidates of the looked up addresses, in address family order
+	Errors     []AddressError     // Errors of the looked up addresses that could not be resolved
+}
+
+// ReverseLookup reverse looks up every address of the machine and picks a hostname among all the PTR records
+// following the selection strategy, it is the implementation of the "RFQDN" method.
+// With SelectFirst the lookups stop at the first address that resolves
+func ReverseLookup(opts ...HostNameOps) (ReverseLookupResult, error) {
+	return reverseLookup(newHostNameConfig(opts))
+}
+
+func getReverseFQDN(config *hostNameConfig) (string, error) {
+	result, err := reverseLookup(config)
+	if err != nil {
+		return "", err
+	}
+	return result.HostName, nil
+}
+
+func reverseLookup(config *hostNameConfig) (ReverseLookupResult, error) {
+	result := ReverseLookupResult{}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return result, err
+	}
+	result.HostName = hostname
+
+	address, err := config.lookupIP(hostname)
+	if err != nil {
+		return result, err
+	}
+
+	for _, addr := range orderAddresses(address, config.addressFamily) {
+		hosts, err := config.lookupAddr(addr.String())
+		if err == nil && len(hosts) == 0 {
+			err = fmt.Errorf("no PTR record found")
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, AddressError{Address: addr, Err: err})
+			continue
+		}
+		for _, host := range hosts {
+			name := strings.TrimSuffix(host, ".") // fqdn without trailing dot
+			if !hasCandidate(result.Candidates, addr, name) {
+				result.Candidates = append(result.Candidates, ReverseCandidate{Address: addr, Name: name})
+			}
+		}
+		if config.strategy == SelectFirst {
+			// the later addresses cannot change the pick, no need to wait for their lookups
+			break
+		}
+	}
+
+	if len(result.Candidates) == 0 {
+		if len(result.Errors) > 0 {
+			// the first address error is wrapped so callers can tell timeouts and missing records apart
+			return result, fmt.Errorf("no reverse DNS name found for %s: %w%s", hostname, result.Errors[0], joinAddressErrors(result.Errors[1:]))
+		}
+		result.Reason = "no address to reverse look up, using the OS hostname"
+		return result, nil
+	}
+
+	if config.strategy == SelectForwardConfirmed {
+		for i := range result.Candidates {
+			result.Candidates[i].ForwardConfirmed = config.forwardConfirmed(result.Candidates[i])
+		}
+	}
+
+	chosen, reason := selectCandidate(result.Candidates, config)
+	if chosen < 0 {
+		return result, fmt.Errorf("none of the %d reverse DNS candidates of %s matched the %s strategy", len(result.Candidates), hostname, config.strategy)
+	}
+	result.Chosen = &result.Candidates[chosen]
+	result.HostName = result.Chosen.Name
+	result.Reason = reason
+	return result, nil
+}
+
+// selectCandidate returns the index of the candidate picked by the strategy and why, -1 if none matches
+func selectCandidate(candidates []ReverseCandidate, config *hostNameConfig) (int, string) {
+	switch config.strategy {
+	case SelectForwardConfirmed:
+		for i, candidate := range candidates {
+			if candidate.ForwardConfirmed {
+				return i, fmt.Sprintf("%s is the first name resolving back to %s", candidate.Name, candidate.Address)
+			}
+		}
+	case SelectLongest:
+		longest := 0
+		for i, candidate := range candidates {
+			if len(candidate.Name) > len(candidates[longest].Name) {
+				longest = i
+			}
+		}
+		return longest, fmt.Sprintf("%s is the longest of %d names", candidates[longest].Name, len(candidates))
+	case SelectDomainSuffix:
+		if config.domainSuffix == "" {
+			return -1, ""
+		}
+		for i, candidate := range candidates {
+			if strings.HasSuffix(strings.ToLower(candidate.Name), "."+strings.ToLower(config.domainSuffix)) {
+				return i, fmt.Sprintf("%s is the first name in the domain %s", candidate.Name, config.domainSuffix)
+			}
+		}
+	default:
+		return 0, fmt.Sprintf("%s is the first PTR record of %s", candidates[0].Name, candidates[0].Address)
+	}
+	return -1, ""
+}
+
+func (c *hostNameConfig) forwardConfirmed(candidate ReverseCandidate) bool {
+	address, err := c.lookupIP(candidate.Name)
+	if err != nil {
+		return false
+	}
+	for _, addr := range address {
+		if addr.Equal(candidate.Address) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *hostNameConfig) lookupIP(host string) ([]net.IP, error) {
+	ctx, cancel := c.lookupContext()
+	defer cancel()
+	ipAddrs, err := c.dnsResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	address := make([]net.IP, 0, len(ipAddrs))
+	for _, ipAddr := range ipAddrs {
+		address = append(address, ipAddr.IP)
+	}
+	return address, nil
+}
+
+func (c *hostNameConfig) lookupAddr(addr string) ([]string, error) {
+	ctx, cancel := c.lookupContext()
+	defer cancel()
+	return c.dnsResolver.LookupAddr(ctx, addr)
+}
+
+func (c *hostNameConfig) lookupContext() (context.Context, context.CancelFunc) {
+	if c.dnsTimeout > 0 {
+		return context.WithTimeout(c.ctx, c.dnsTimeout)
+	}
+	return context.WithCancel(c.ctx)
+}
+
+func hasCandidate(candidates []ReverseCandidate, addr net.IP, name string) bool {
+	for _, candidate := range candidates {
+		if candidate.Address.Equal(addr) && candidate.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func joinAddressErrors(errs []AddressError) string {
+	msg := ""
+	for _, err := range errs {
+		msg += "; " + err.Error()
+	}
+	return msg
+}
+
+// orderAddresses filters and sorts the addresses following the address family preference.
+// IPv6 link-local addresses are left out as they cannot be looked up without a zone
+func orderAddresses(address []net.IP, family AddressFamily) []net.IP {
+	var ipv4, ipv6 []net.IP
+	for _, addr := range address {
+		if ip := addr.To4(); ip != nil {
+			ipv4 = append(ipv4, ip)
+		} else if ip := addr.To16(); ip != nil && !ip.IsLinkLocalUnicast() {
+			ipv6 = append(ipv6, ip)
+		}
+	}
+	switch family {
+	case IPv6First:
+		return append(ipv6, ipv4...)
+	case IPv4Only:
+		return ipv4
+	case IPv6Only:
+		return ipv6
+	default:
+		return append(ipv4, ipv6...)
+	}
+}
@@ -0,0 +1,182 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package netutils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeDNS is an in-memory DNSResolver
+type fakeDNS struct {
+	hosts map[string][]string // hosts name:addresses
+	addrs map[string][]string // addrs address:names
+	delay time.Duration
+}
+
+func (f *fakeDNS) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	address, ok := f.hosts[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	ipAddrs := make([]net.IPAddr, 0, len(address))
+	for _, addr := range address {
+		ipAddrs = append(ipAddrs, net.IPAddr{IP: net.ParseIP(addr)})
+	}
+	return ipAddrs, nil
+}
+
+func (f *fakeDNS) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	select {
+	case <-time.After(f.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	names, ok := f.addrs[addr]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+	return names, nil
+}
+
+func TestOrderAddresses(t *testing.T) {
+	address := []net.IP{
+		net.ParseIP("2001:db8::1"),
+		net.ParseIP("10.0.0.1"),
+		net.ParseIP("fe80::1"),
+		net.ParseIP("2001:db8::2"),
+		net.ParseIP("10.0.0.2"),
+	}
+	tests := []struct {
+		name   string
+		family AddressFamily
+		want   []string
+	}{
+		{name: "IPv4 first", family: IPv4First, want: []string{"10.0.0.1", "10.0.0.2", "2001:db8::1", "2001:db8::2"}},
+		{name: "IPv6 first", family: IPv6First, want: []string{"2001:db8::1", "2001:db8::2", "10.0.0.1", "10.0.0.2"}},
+		{name: "IPv4 only", family: IPv4Only, want: []string{"10.0.0.1", "10.0.0.2"}},
+		{name: "IPv6 only", family: IPv6Only, want: []string{"2001:db8::1", "2001:db8::2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, ip := range orderAddresses(address, tt.family) {
+				got = append(got, ip.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("orderAddresses() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectCandidate(t *testing.T) {
+	candidates := []ReverseCandidate{
+		{Address: net.ParseIP("10.0.0.1"), Name: "node-1"},
+		{Address: net.ParseIP("10.0.0.1"), Name: "node-1.dc1.example.com", ForwardConfirmed: true},
+		{Address: net.ParseIP("10.0.0.2"), Name: "node-1.Example.org"},
+	}
+	tests := []struct {
+		name     string
+		strategy SelectionStrategy
+		suffix   string
+		want     int
+	}{
+		{name: "first", strategy: SelectFirst, want: 0},
+		{name: "forward confirmed", strategy: SelectForwardConfirmed, want: 1},
+		{name: "longest", strategy: SelectLongest, want: 1},
+		{name: "domain suffix", strategy: SelectDomainSuffix, suffix: "example.org", want: 2},
+		{name: "domain suffix without match", strategy: SelectDomainSuffix, suffix: "example.net", want: -1},
+		{name: "domain suffix not set", strategy: SelectDomainSuffix, want: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := newHostNameConfig([]HostNameOps{WithSelectionStrategy(tt.strategy), WithDomainSuffix(tt.suffix)})
+			got, reason := selectCandidate(candidates, config)
+			if got != tt.want {
+				t.Errorf("selectCandidate() got = %v (%s), want %v", got, reason, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseLookup(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("cannot get the OS hostname: %v", err)
+	}
+	dns := &fakeDNS{
+		hosts: map[string][]string{
+			hostname:                 {"10.0.0.1", "10.0.0.2", "2001:db8::1"},
+			"node-1.dc1.example.com": {"10.0.0.2"},
+		},
+		addrs: map[string][]string{
+			"10.0.0.2":    {"node-1.dc1.example.com.", "alias.example.com."},
+			"2001:db8::1": {"node-1-v6.example.com."},
+		},
+	}
+	tests := []struct {
+		name       string
+		opts       []HostNameOps
+		want       string
+		candidates int
+		errs       int
+		wantErr    bool
+	}{
+		{name: "first PTR after a failing address", want: "node-1.dc1.example.com", candidates: 2, errs: 1},
+		{name: "IPv6 first", opts: []HostNameOps{WithAddressFamily(IPv6First)}, want: "node-1-v6.example.com", candidates: 1, errs: 0},
+		{name: "IPv4 only", opts: []HostNameOps{WithAddressFamily(IPv4Only)}, want: "node-1.dc1.example.com", candidates: 2, errs: 1},
+		{name: "forward confirmed", opts: []HostNameOps{WithAddressFamily(IPv6First), WithSelectionStrategy(SelectForwardConfirmed)}, want: "node-1.dc1.example.com", candidates: 3, errs: 1},
+		{name: "domain suffix", opts: []HostNameOps{WithSelectionStrategy(SelectDomainSuffix), WithDomainSuffix("dc1.example.com")}, want: "node-1.dc1.example.com", candidates: 3, errs: 1},
+		{name: "domain suffix without match", opts: []HostNameOps{WithSelectionStrategy(SelectDomainSuffix), WithDomainSuffix("example.net")}, want: hostname, candidates: 3, errs: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ReverseLookup(append(tt.opts, WithDNSResolver(dns))...)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ReverseLookup() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got.HostName != tt.want || len(got.Candidates) != tt.candidates || len(got.Errors) != tt.errs {
+				t.Errorf("ReverseLookup() got = %+v, want %v with %d candidates and %d errors", got, tt.want, tt.candidates, tt.errs)
+			}
+		})
+	}
+}
+
+func TestReverseLookupTimeout(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("cannot get the OS hostname: %v", err)
+	}
+	dns := &fakeDNS{
+		hosts: map[string][]string{hostname: {"10.0.0.1"}},
+		addrs: map[string][]string{"10.0.0.1": {"node-1.example.com."}},
+		delay: time.Second,
+	}
+	_, err = GetHostName("RFQDN", 0, WithDNSResolver(dns), WithDNSTimeout(10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetHostName() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if timeout := newHostNameConfig(nil).dnsTimeout; timeout != DefaultDNSTimeout {
+		t.Errorf("default DNS timeout got = %v, want %v", timeout, DefaultDNSTimeout)
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"sync"
+	"time"
 )
 
 // Command is an instance of an executable command
@@ -27,15 +29,76 @@ type Command struct {
 	Command string
 	Args    []string
 	Status  Status
+	History []Status // History of the attempts of the last Run with WithRetry, the last one is also in Status
 	Ctx     context.Context
+
+	stdout       output
+	stderr       output
+	combined     bool
+	env          []string
+	noInheritEnv bool
+	dir          string
+	stdin        io.Reader
+	user         string
+	group        string
+	credential   *Credential
+	termination  TerminationPolicy
+	retry        *RetryPolicy
+
+	// pipeIn and pipeOut replace stdin and stdout when the command is a stage of a Pipeline
+	pipeIn  *os.File
+	pipeOut *os.File
+
+	mutex   sync.Mutex
+	running *execution
+}
+
+// execution is a started instance of the command
+type execution struct {
+	cmd     *exec.Cmd
+	done    chan struct{}
+	waitErr error
+
+	// ctxErr is set when the context of the command triggered its termination
+	mutex  sync.Mutex
+	ctxErr error
+}
+
+// contextDone records that the context triggered the termination of the command
+func (run *execution) contextDone(err error) {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	run.ctxErr = err
+}
+
+func (run *execution) contextErr() error {
+	run.mutex.Lock()
+	defer run.mutex.Unlock()
+	return run.ctxErr
+}
+
+// Credential is the numeric identity a command runs as
+type Credential struct {
+	UID    uint32
+	GID    uint32
+	Groups []uint32 // Groups are the supplementary group ids, nil keeps the ones of the current process
 }
 
 // Status contains information about an executed command instance
 type Status struct {
-	Process  *os.Process
-	ExitCode int
-	StdOut   string
-	StdErr   string
+	Process        *os.Process
+	ExitCode       int // ExitCode of the command, -1 if it could not be started or was terminated by a signal
+	StdOut         string
+	StdErr         string
+	Combined       string        // Combined stdout and stderr in the order they were written, see WithCombinedOutput
+	StdOutBytes    int64         // StdOutBytes written by the command, of the combined output with WithCombinedOutput
+	StdErrBytes    int64         // StdErrBytes written by the command
+	Truncated      bool          // Truncated is true if some output was left out because of the capture limits
+	Signal         os.Signal     // Signal that terminated the command, nil if it exited
+	ContextExpired bool          // ContextExpired is true if the command was killed because its context was done
+	StartTime      time.Time     // StartTime of the command
+	EndTime        time.Time     // EndTime of the command
+	Duration       time.Duration // Duration of the command
 }
 
 // New returns a new command instance
@@ -48,48 +111,301 @@ func New(ctx context.Context, command string, args []string) *Command {
 	}
 }
 
-// Run execute the command and returns the command execution status, stdout and stderr
+// Run execute the command and returns the command execution status, stdout and stderr.
+// A non-zero exit code is not an error, the returned error wraps ErrNotFound or ErrPermission
+// when the command cannot be started, ErrTimeout when its context expired and ErrSignaled when it is
+// terminated by a signal.
+// The command runs in its own process group, which is terminated following the termination policy
+// when the context is done. With WithRetry the command is rerun following the retry policy
 func (c *Command) Run() (*Command, error) {
-	cmd := exec.CommandContext(c.Ctx, c.Command, c.Args...)
-
-	stdout, err := cmd.StdoutPipe()
-	if err != nil {
+	if c.retry != nil {
+		return c.runWithRetry()
+	}
+	if err := c.Start(); err != nil {
 		return c, err
 	}
+	return c.Wait()
+}
 
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return c, err
+// Start starts the command without waiting for it to exit, see Run for the returned errors.
+// Status is reset by Start and filled once the command has exited, see Done and Wait
+func (c *Command) Start() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.running != nil {
+		select {
+		case <-c.running.done:
+		default:
+			return ErrAlreadyStarted
+		}
+	}
+
+	c.Status = Status{}
+	cmd := exec.Command(c.Command, c.Args...)
+	cmd.Env = c.environ()
+	cmd.Dir = c.dir
+	cmd.Stdin = c.stdin
+	if c.pipeIn != nil {
+		cmd.Stdin = c.pipeIn
+	}
+	c.Status.StartTime = time.Now()
+	if err := c.setSysProcAttr(cmd); err != nil {
+		c.Status.EndTime = time.Now()
+		c.Status.ExitCode = -1
+		return err
+	}
+
+	// exec copies each stream on its own goroutine so a full stderr pipe never blocks the stdout one
+	var stdoutCapture, stderrCapture, combinedCapture *captureBuffer
+	var stdoutLines, stderrLines []*lineWriter
+	switch {
+	case c.pipeOut != nil && c.combined:
+		cmd.Stdout = c.pipeOut
+		cmd.Stderr = c.pipeOut
+	case c.pipeOut != nil:
+		cmd.Stdout = c.pipeOut
+		stderrCapture, stderrLines, cmd.Stderr = c.newOutput(c.stderr)
+	case c.combined:
+		// the same writer for both streams makes exec share a single pipe, which keeps the write order
+		var combined io.Writer
+		combinedCapture, stdoutLines, combined = c.newOutput(mergeOutputs(c.stdout, c.stderr))
+		cmd.Stdout = combined
+		cmd.Stderr = combined
+	default:
+		stdoutCapture, stdoutLines, cmd.Stdout = c.newOutput(c.stdout)
+		stderrCapture, stderrLines, cmd.Stderr = c.newOutput(c.stderr)
 	}
 
 	if err := cmd.Start(); err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			c.Status.ExitCode = exitError.ExitCode()
+		c.Status.EndTime = time.Now()
+		c.Status.ExitCode = -1
+		return startError(err)
+	}
+	c.Status.Process = cmd.Process
+	run := &execution{cmd: cmd, done: make(chan struct{})}
+	c.running = run
+
+	go func() {
+		select {
+		case <-c.Ctx.Done():
+			// recorded before signaling, so Wait cannot return before it
+			run.contextDone(c.Ctx.Err())
+			_ = c.terminate(run)
+		case <-run.done:
+		}
+	}()
+	go func() {
+		err := cmd.Wait()
+		waitErr := waitError(run.contextErr(), err, &c.Status)
+		c.Status.EndTime = time.Now()
+		c.Status.Duration = c.Status.EndTime.Sub(c.Status.StartTime)
+		flushLines(stdoutLines)
+		flushLines(stderrLines)
+		if stdoutCapture != nil {
+			c.Status.StdOut = stdoutCapture.String()
+			c.Status.StdOutBytes = stdoutCapture.total
+			c.Status.Truncated = stdoutCapture.truncated() > 0
+		}
+		if stderrCapture != nil {
+			c.Status.StdErr = stderrCapture.String()
+			c.Status.StdErrBytes = stderrCapture.total
+			c.Status.Truncated = c.Status.Truncated || stderrCapture.truncated() > 0
 		}
+		if combinedCapture != nil {
+			c.Status.Combined = combinedCapture.String()
+			c.Status.StdOutBytes = combinedCapture.total
+			c.Status.Truncated = combinedCapture.truncated() > 0
+		}
+		run.waitErr = waitErr
+		close(run.done)
+	}()
+	return nil
+}
+
+// Wait waits for the started command to exit and returns the same as Run
+func (c *Command) Wait() (*Command, error) {
+	run := c.execution()
+	if run == nil {
+		return c, ErrNotRunning
 	}
+	<-run.done
+	return c, run.waitErr
+}
 
-	stdoutByte, err := io.ReadAll(stdout)
-	if err != nil {
-		return c, err
+// Done returns a channel closed once the started command has exited and Status is filled,
+// nil if the command was never started
+func (c *Command) Done() <-chan struct{} {
+	run := c.execution()
+	if run == nil {
+		return nil
 	}
+	return run.done
+}
 
-	stderrByte, err := io.ReadAll(stderr)
-	if err != nil {
-		return c, err
+// Pid returns the process id of the started command, 0 if the command was never started
+func (c *Command) Pid() int {
+	run := c.execution()
+	if run == nil {
+		return 0
 	}
+	return run.cmd.Process.Pid
+}
 
-	if err := cmd.Wait(); err != nil {
-		if exitError, ok := err.(*exec.ExitError); ok {
-			c.Status.ExitCode = exitError.ExitCode()
-		}
+// Signal sends the signal to the running command only, Kill terminates its whole process group
+func (c *Command) Signal(sig os.Signal) error {
+	run := c.execution()
+	if run == nil {
+		return ErrNotRunning
 	}
-	c.Status.Process = cmd.Process
-	c.Status.StdOut = string(stdoutByte)
-	c.Status.StdErr = string(stderrByte)
-	return c, nil
+	select {
+	case <-run.done:
+		return ErrNotRunning
+	default:
+	}
+	return run.cmd.Process.Signal(sig)
+}
+
+func (c *Command) execution() *execution {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.running
+}
+
+// newOutput returns the writer a command stream is copied to, made of the capture buffer and the stream sinks
+func (c *Command) newOutput(o output) (*captureBuffer, []*lineWriter, io.Writer) {
+	capture := &captureBuffer{limit: o.maxBytes, keep: o.keep}
+	writers := make([]io.Writer, 0, len(o.writers)+len(o.lineFns)+1)
+	writers = append(writers, capture)
+	writers = append(writers, o.writers...)
+	lines := make([]*lineWriter, 0, len(o.lineFns))
+	for _, fn := range o.lineFns {
+		line := &lineWriter{fn: fn}
+		lines = append(lines, line)
+		writers = append(writers, line)
+	}
+	return capture, lines, io.MultiWriter(writers...)
+}
+
+func flushLines(lines []*lineWriter) {
+	for _, line := range lines {
+		line.flush()
+	}
+}
+
+// WithStdout copies the stdout of the command to w while it runs, in addition to Status.StdOut
+func (c *Command) WithStdout(w io.Writer) *Command {
+	c.stdout.writers = append(c.stdout.writers, w)
+	return c
+}
+
+// WithStderr copies the stderr of the command to w while it runs, in addition to Status.StdErr.
+// stdout and stderr are copied concurrently, a writer shared by both streams must be safe for concurrent use
+func (c *Command) WithStderr(w io.Writer) *Command {
+	c.stderr.writers = append(c.stderr.writers, w)
+	return c
+}
+
+// WithStdoutLines calls fn for every line of stdout while the command runs, without the line ending
+func (c *Command) WithStdoutLines(fn func(line string)) *Command {
+	c.stdout.lineFns = append(c.stdout.lineFns, fn)
+	return c
+}
+
+// WithStderrLines calls fn for every line of stderr while the command runs, without the line ending
+func (c *Command) WithStderrLines(fn func(line string)) *Command {
+	c.stderr.lineFns = append(c.stderr.lineFns, fn)
+	return c
+}
+
+// WithCaptureTail keeps only the last maxBytes bytes of each stream in Status.StdOut and Status.StdErr.
+// Zero captures everything (the default) and a negative value captures nothing
+func (c *Command) WithCaptureTail(maxBytes int) *Command {
+	c.WithStdoutLimit(maxBytes, KeepTail)
+	return c.WithStderrLimit(maxBytes, KeepTail)
+}
+
+// WithStdoutLimit keeps at most maxBytes bytes of stdout in Status.StdOut, or Status.Combined with
+// WithCombinedOutput, following the retention. A marker tells how many bytes were left out where they were.
+// Zero captures everything (the default) and a negative value captures nothing
+func (c *Command) WithStdoutLimit(maxBytes int, keep Retention) *Command {
+	c.stdout.maxBytes, c.stdout.keep = maxBytes, keep
+	return c
+}
+
+// WithStderrLimit keeps at most maxBytes bytes of stderr in Status.StdErr following the retention,
+// see WithStdoutLimit
+func (c *Command) WithStderrLimit(maxBytes int, keep Retention) *Command {
+	c.stderr.maxBytes, c.stderr.keep = maxBytes, keep
+	return c
+}
+
+// WithCombinedOutput merges stderr into stdout like 2>&1, keeping the order in which they were written.
+// The merged output is captured in Status.Combined and copied to the sinks of both streams
+func (c *Command) WithCombinedOutput() *Command {
+	c.combined = true
+	return c
+}
+
+// WithEnv adds environment variables in the "KEY=value" form to the environment of the command.
+// When a key is set more than once the last value is used
+func (c *Command) WithEnv(env ...string) *Command {
+	c.env = append(c.env, env...)
+	return c
+}
+
+// WithInheritEnv sets whether the command inherits the environment of the current process, true by default.
+// When false the command only gets the variables set with WithEnv
+func (c *Command) WithInheritEnv(inherit bool) *Command {
+	c.noInheritEnv = !inherit
+	return c
+}
+
+// WithDir sets the working directory of the command, the current directory by default
+func (c *Command) WithDir(dir string) *Command {
+	c.dir = dir
+	return c
+}
+
+// WithStdin sets the reader the stdin of the command is read from, no input by default
+func (c *Command) WithStdin(stdin io.Reader) *Command {
+	c.stdin = stdin
+	return c
+}
+
+// WithUser runs the command as the user with the given name or id, with its primary and supplementary groups.
+// The current process needs the privileges to switch users, usually root
+func (c *Command) WithUser(name string) *Command {
+	c.user = name
+	return c
+}
+
+// WithGroup runs the command with the group with the given name or id as primary group
+func (c *Command) WithGroup(name string) *Command {
+	c.group = name
+	return c
+}
+
+// WithCredential runs the command as the given uid, gid and supplementary groups, WithUser and WithGroup are ignored
+func (c *Command) WithCredential(uid, gid uint32, groups ...uint32) *Command {
+	// a non-nil slice clears the supplementary groups of the current process
+	c.credential = &Credential{UID: uid, GID: gid, Groups: append([]uint32{}, groups...)}
+	return c
+}
+
+// environ returns the environment of the command, nil to let exec inherit the current one
+func (c *Command) environ() []string {
+	if c.noInheritEnv {
+		return append([]string{}, c.env...)
+	}
+	if len(c.env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), c.env...)
 }
 
 // WithExpression creates a new Command with the specified command binary and the expression
+// The expression is passed as it is to the shell, values coming from users must be quoted with Quote
+// or formatted with WithExpressionf
 func (c *Command) WithExpression(cmdBin string, expression string) *Command {
 	c.Command = cmdBin
 	c.Args = []string{"-c", expression}
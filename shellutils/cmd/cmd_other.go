@@ -0,0 +1,43 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !unix
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// ErrUnknownUser is returned when the user or group set with WithUser or WithGroup does not exist
+var ErrUnknownUser = errors.New("unknown user or group")
+
+func (c *Command) setSysProcAttr(cmd *exec.Cmd) error {
+	if c.credential != nil || c.user != "" || c.group != "" {
+		return fmt.Errorf("running a command as another user is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// signalGroup only signals the process itself as process groups are not supported
+func signalGroup(process *os.Process, sig os.Signal) error {
+	if sig == os.Kill {
+		return process.Kill()
+	}
+	return process.Signal(sig)
+}
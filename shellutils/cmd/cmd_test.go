@@ -0,0 +1,261 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandStreaming(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stdout bytes.Buffer
+	var stdoutLines, stderrLines []string
+	c := New(ctx, "", nil).
+		WithExpression("sh", "printf 'one\\ntwo\\n'; printf 'err\\n' >&2; printf 'three'").
+		WithStdout(&stdout).
+		WithStdoutLines(func(line string) { stdoutLines = append(stdoutLines, line) }).
+		WithStderrLines(func(line string) { stderrLines = append(stderrLines, line) })
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := stdout.String(); got != "one\ntwo\nthree" {
+		t.Errorf("stdout writer got = %q", got)
+	}
+	if want := []string{"one", "two", "three"}; !reflect.DeepEqual(stdoutLines, want) {
+		t.Errorf("stdout lines got = %q, want %q", stdoutLines, want)
+	}
+	if want := []string{"err"}; !reflect.DeepEqual(stderrLines, want) {
+		t.Errorf("stderr lines got = %q, want %q", stderrLines, want)
+	}
+	if c.Status.StdOut != "one\ntwo\nthree" || c.Status.StdErr != "err\n" {
+		t.Errorf("Status got = %q %q", c.Status.StdOut, c.Status.StdErr)
+	}
+}
+
+func TestCommandCaptureLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       *Command
+		wantStdOut    string
+		wantStdErr    string
+		wantTruncated bool
+		wantBytes     int64
+	}{
+		{
+			name:       "everything",
+			command:    New(context.Background(), "printf", []string{"0123456789"}).WithCaptureTail(0),
+			wantStdOut: "0123456789",
+			wantBytes:  10,
+		},
+		{
+			name:          "tail",
+			command:       New(context.Background(), "printf", []string{"0123456789"}).WithCaptureTail(4),
+			wantStdOut:    "[... 6 bytes truncated ...]\n6789",
+			wantTruncated: true,
+			wantBytes:     10,
+		},
+		{
+			name:       "nothing",
+			command:    New(context.Background(), "printf", []string{"0123456789"}).WithCaptureTail(-1),
+			wantStdOut: "",
+			wantBytes:  10,
+		},
+		{
+			name:       "within the limit",
+			command:    New(context.Background(), "printf", []string{"0123456789"}).WithStdoutLimit(10, KeepHead),
+			wantStdOut: "0123456789",
+			wantBytes:  10,
+		},
+		{
+			name:          "head",
+			command:       New(context.Background(), "printf", []string{"0123456789"}).WithStdoutLimit(3, KeepHead),
+			wantStdOut:    "012\n[... 7 bytes truncated ...]",
+			wantTruncated: true,
+			wantBytes:     10,
+		},
+		{
+			name:          "head and tail",
+			command:       New(context.Background(), "printf", []string{"0123456789"}).WithStdoutLimit(4, KeepHeadAndTail),
+			wantStdOut:    "01\n[... 6 bytes truncated ...]\n89",
+			wantTruncated: true,
+			wantBytes:     10,
+		},
+		{
+			name:          "stderr only",
+			command:       New(context.Background(), "", nil).WithExpression("sh", "printf 0123456789 >&2; printf out").WithStderrLimit(2, KeepTail),
+			wantStdOut:    "out",
+			wantStdErr:    "[... 8 bytes truncated ...]\n89",
+			wantTruncated: true,
+			wantBytes:     13,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.command.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			status := tt.command.Status
+			if status.StdOut != tt.wantStdOut || status.StdErr != tt.wantStdErr || status.Truncated != tt.wantTruncated {
+				t.Errorf("Status got = %q %q truncated %v, want %q %q truncated %v",
+					status.StdOut, status.StdErr, status.Truncated, tt.wantStdOut, tt.wantStdErr, tt.wantTruncated)
+			}
+			if status.StdOutBytes+status.StdErrBytes != tt.wantBytes {
+				t.Errorf("Status got = %d stdout bytes and %d stderr bytes", status.StdOutBytes, status.StdErrBytes)
+			}
+		})
+	}
+}
+
+func TestCommandCaptureLimitLargeOutput(t *testing.T) {
+	c := New(context.Background(), "", nil).
+		WithExpression("sh", "head -c 10485760 /dev/zero; printf end").
+		WithStdoutLimit(1024, KeepHeadAndTail)
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !c.Status.Truncated || c.Status.StdOutBytes != 10485763 || len(c.Status.StdOut) > 1024+64 {
+		t.Errorf("Status got = truncated %v, %d bytes, %d captured", c.Status.Truncated, c.Status.StdOutBytes, len(c.Status.StdOut))
+	}
+	if !strings.HasSuffix(c.Status.StdOut, "end") {
+		t.Errorf("Status.StdOut got = %q, want the tail of the output", c.Status.StdOut[len(c.Status.StdOut)-16:])
+	}
+}
+
+func TestCommandLargeStderr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// far more than a pipe buffer on stderr before anything is written to stdout
+	c := New(ctx, "", nil).WithExpression("sh", "head -c 1048576 /dev/zero >&2; echo done")
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatalf("Run() did not return before the context deadline")
+	}
+	if c.Status.StdOut != "done\n" || len(c.Status.StdErr) != 1048576 {
+		t.Errorf("Status got = %q and %d stderr bytes", c.Status.StdOut, len(c.Status.StdErr))
+	}
+}
+
+func TestCommandCombinedOutput(t *testing.T) {
+	c := New(context.Background(), "", nil).
+		WithExpression("sh", "echo out1; echo err1 >&2; echo out2; echo err2 >&2").
+		WithCombinedOutput()
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if want := "out1\nerr1\nout2\nerr2\n"; c.Status.Combined != want {
+		t.Errorf("Status.Combined got = %q, want %q", c.Status.Combined, want)
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	notExecutable := filepath.Join(t.TempDir(), "script.sh")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\necho hello\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		command      *Command
+		timeout      time.Duration
+		wantErr      error
+		wantExitCode int
+		wantSignal   os.Signal
+		wantExpired  bool
+	}{
+		{name: "success", command: New(context.Background(), "true", nil)},
+		{name: "non-zero exit", command: New(context.Background(), "sh", []string{"-c", "exit 3"}), wantExitCode: 3},
+		{name: "not found", command: New(context.Background(), "/nonexistent/binary", nil), wantErr: ErrNotFound, wantExitCode: -1},
+		{name: "not in path", command: New(context.Background(), "nonexistent-binary-name", nil), wantErr: ErrNotFound, wantExitCode: -1},
+		{name: "permission denied", command: New(context.Background(), notExecutable, nil), wantErr: ErrPermission, wantExitCode: -1},
+		{name: "timeout", command: New(context.Background(), "sleep", []string{"10"}), timeout: 50 * time.Millisecond, wantErr: ErrTimeout, wantExitCode: -1, wantSignal: syscall.SIGKILL, wantExpired: true},
+		{name: "signaled", command: New(context.Background(), "sh", []string{"-c", "kill -TERM $$"}), wantErr: ErrSignaled, wantExitCode: -1, wantSignal: syscall.SIGTERM},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), tt.timeout)
+			}
+			defer cancel()
+			tt.command.Ctx = ctx
+
+			_, err := tt.command.Run()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			status := tt.command.Status
+			if status.ExitCode != tt.wantExitCode || status.Signal != tt.wantSignal || status.ContextExpired != tt.wantExpired {
+				t.Errorf("Status got = exit code %d, signal %v, expired %v", status.ExitCode, status.Signal, status.ContextExpired)
+			}
+			if status.StartTime.IsZero() || status.EndTime.Before(status.StartTime) {
+				t.Errorf("Status got = start %v, end %v", status.StartTime, status.EndTime)
+			}
+		})
+	}
+}
+
+func TestCommandEnvDirStdin(t *testing.T) {
+	t.Setenv("CMD_TEST_INHERITED", "inherited")
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		command *Command
+		want    string
+	}{
+		{
+			name:    "env added to the inherited one",
+			command: New(context.Background(), "", nil).WithExpression("sh", "echo $CMD_TEST_INHERITED $CMD_TEST_VAR").WithEnv("CMD_TEST_VAR=first", "CMD_TEST_VAR=value"),
+			want:    "inherited value\n",
+		},
+		{
+			name:    "env not inherited",
+			command: New(context.Background(), "/bin/sh", []string{"-c", "echo ${CMD_TEST_INHERITED:-unset} $CMD_TEST_VAR"}).WithEnv("CMD_TEST_VAR=value").WithInheritEnv(false),
+			want:    "unset value\n",
+		},
+		{
+			name:    "working directory",
+			command: New(context.Background(), "pwd", nil).WithDir(dir),
+			want:    dir + "\n",
+		},
+		{
+			name:    "stdin",
+			command: New(context.Background(), "cat", nil).WithStdin(bytes.NewBufferString("from stdin")),
+			want:    "from stdin",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.command.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if tt.command.Status.StdOut != tt.want {
+				t.Errorf("Status.StdOut got = %q, want %q", tt.command.Status.StdOut, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// ErrUnknownUser is returned when the user or group set with WithUser or WithGroup does not exist
+var ErrUnknownUser = errors.New("unknown user or group")
+
+// setSysProcAttr starts the command in its own process group, as the user set with the credential options
+func (c *Command) setSysProcAttr(cmd *exec.Cmd) error {
+	credential, err := c.resolveCredential()
+	if err != nil {
+		return err
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if credential != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Uid:         credential.UID,
+			Gid:         credential.GID,
+			Groups:      credential.Groups,
+			NoSetGroups: credential.Groups == nil,
+		}
+	}
+	return nil
+}
+
+// signalGroup sends the signal to every process of the process group led by the process
+func signalGroup(process *os.Process, sig os.Signal) error {
+	unixSig, ok := sig.(syscall.Signal)
+	if !ok {
+		return process.Signal(sig)
+	}
+	err := syscall.Kill(-process.Pid, unixSig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// resolveCredential returns the identity set with WithCredential, WithUser and WithGroup, nil if none is set
+func (c *Command) resolveCredential() (*Credential, error) {
+	if c.credential != nil {
+		return c.credential, nil
+	}
+	if c.user == "" && c.group == "" {
+		return nil, nil
+	}
+
+	credential := &Credential{UID: uint32(os.Getuid()), GID: uint32(os.Getgid())}
+	if c.user != "" {
+		u, err := lookupUser(c.user)
+		if err != nil {
+			return nil, err
+		}
+		if credential.UID, err = parseID(u.Uid); err != nil {
+			return nil, err
+		}
+		if credential.GID, err = parseID(u.Gid); err != nil {
+			return nil, err
+		}
+		groupIds, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get the groups of user %q: %w", c.user, err)
+		}
+		credential.Groups = make([]uint32, 0, len(groupIds))
+		for _, groupID := range groupIds {
+			gid, err := parseID(groupID)
+			if err != nil {
+				return nil, err
+			}
+			credential.Groups = append(credential.Groups, gid)
+		}
+	}
+	if c.group != "" {
+		g, err := lookupGroup(c.group)
+		if err != nil {
+			return nil, err
+		}
+		if credential.GID, err = parseID(g.Gid); err != nil {
+			return nil, err
+		}
+	}
+	return credential, nil
+}
+
+func lookupUser(name string) (*user.User, error) {
+	u, err := user.Lookup(name)
+	if _, unknown := err.(user.UnknownUserError); unknown {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+			u, err = user.LookupId(name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: user %q: %v", ErrUnknownUser, name, err)
+	}
+	return u, nil
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	g, err := user.LookupGroup(name)
+	if _, unknown := err.(user.UnknownGroupError); unknown {
+		if _, numErr := strconv.ParseUint(name, 10, 32); numErr == nil {
+			g, err = user.LookupGroupId(name)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: group %q: %v", ErrUnknownUser, name, err)
+	}
+	return g, nil
+}
+
+func parseID(id string) (uint32, error) {
+	parsed, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid user or group id %q: %w", id, err)
+	}
+	return uint32(parsed), nil
+}
@@ -0,0 +1,237 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build unix
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestCommandUnknownUser(t *testing.T) {
+	tests := []struct {
+		name    string
+		command *Command
+	}{
+		{name: "user", command: New(context.Background(), "id", nil).WithUser("no-such-user-for-cmd-test")},
+		{name: "group", command: New(context.Background(), "id", nil).WithGroup("no-such-group-for-cmd-test")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.command.Run()
+			if !errors.Is(err, ErrUnknownUser) {
+				t.Errorf("Run() error = %v, want %v", err, ErrUnknownUser)
+			}
+			if tt.command.Status.ExitCode != -1 {
+				t.Errorf("Status.ExitCode got = %d, want -1", tt.command.Status.ExitCode)
+			}
+		})
+	}
+}
+
+func TestCommandAsUser(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("running a command as another user needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skipf("user nobody does not exist: %v", err)
+	}
+	tests := []struct {
+		name    string
+		command *Command
+		want    string
+	}{
+		{name: "by name", command: New(context.Background(), "id", []string{"-u"}).WithUser("nobody"), want: nobody.Uid},
+		{name: "by id", command: New(context.Background(), "id", []string{"-u"}).WithUser(nobody.Uid), want: nobody.Uid},
+		{name: "group", command: New(context.Background(), "id", []string{"-g"}).WithUser("nobody").WithGroup(nobody.Gid), want: nobody.Gid},
+		{name: "credential", command: New(context.Background(), "id", []string{"-u"}).WithCredential(65000, 65000), want: "65000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.command.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := strings.TrimSpace(tt.command.Status.StdOut); got != tt.want {
+				t.Errorf("Status.StdOut got = %q, want %q (stderr %q)", got, tt.want, tt.command.Status.StdErr)
+			}
+		})
+	}
+}
+
+func TestCommandTermination(t *testing.T) {
+	tests := []struct {
+		name        string
+		expression  string
+		signal      os.Signal
+		gracePeriod time.Duration
+		wantSignal  os.Signal
+		wantExit    int
+		wantStdOut  string
+	}{
+		{
+			name:       "process group killed",
+			expression: "sleep 30 & echo $!; wait",
+			wantSignal: syscall.SIGKILL,
+			wantExit:   -1,
+		},
+		{
+			name:        "graceful",
+			expression:  "trap 'echo terminated; exit 0' TERM; sleep 30 & echo $!; wait",
+			signal:      syscall.SIGTERM,
+			gracePeriod: 10 * time.Second,
+			wantStdOut:  "terminated\n",
+		},
+		{
+			name:        "killed after the grace period",
+			expression:  "trap '' TERM; sleep 30 & echo $!; wait",
+			signal:      syscall.SIGTERM,
+			gracePeriod: 100 * time.Millisecond,
+			wantSignal:  syscall.SIGKILL,
+			wantExit:    -1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			c := New(ctx, "", nil).WithExpression("sh", tt.expression).WithTermination(tt.signal, tt.gracePeriod)
+
+			start := time.Now()
+			_, err := c.Run()
+			// a command trapping the termination signal and exiting 0 still timed out
+			if !errors.Is(err, ErrTimeout) || !c.Status.ContextExpired {
+				t.Errorf("Run() error = %v with ContextExpired %v, want %v", err, c.Status.ContextExpired, ErrTimeout)
+			}
+			if c.Status.ExitCode != tt.wantExit {
+				t.Errorf("Status.ExitCode got = %d, want %d", c.Status.ExitCode, tt.wantExit)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Run() returned after %v, the process group was not terminated", elapsed)
+			}
+			if c.Status.Signal != tt.wantSignal {
+				t.Errorf("Status.Signal got = %v, want %v", c.Status.Signal, tt.wantSignal)
+			}
+			lines := strings.SplitN(c.Status.StdOut, "\n", 2)
+			if len(lines) < 2 || lines[1] != tt.wantStdOut {
+				t.Errorf("Status.StdOut got = %q, want the background pid then %q", c.Status.StdOut, tt.wantStdOut)
+			}
+			assertNotRunning(t, lines[0])
+		})
+	}
+}
+
+func TestCommandKill(t *testing.T) {
+	c := New(context.Background(), "", nil).WithExpression("sh", "sleep 30 & echo $!; wait")
+	if err := c.Kill(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Kill() before Run error = %v, want %v", err, ErrNotRunning)
+	}
+	ran := make(chan error)
+	go func() {
+		_, err := c.Run()
+		ran <- err
+	}()
+	for err := c.Kill(); errors.Is(err, ErrNotRunning); err = c.Kill() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-ran:
+		if !errors.Is(err, ErrSignaled) {
+			t.Errorf("Run() error = %v, want %v", err, ErrSignaled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Run() did not return after Kill()")
+	}
+	assertNotRunning(t, strings.SplitN(c.Status.StdOut, "\n", 2)[0])
+}
+
+func TestCommandStartWait(t *testing.T) {
+	fast := New(context.Background(), "sh", []string{"-c", "echo fast"})
+	slow := New(context.Background(), "sleep", []string{"30"})
+	if fast.Done() != nil || fast.Pid() != 0 {
+		t.Errorf("Done() and Pid() before Start should be nil and 0")
+	}
+	for _, c := range []*Command{fast, slow} {
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+		if c.Pid() <= 0 {
+			t.Errorf("Pid() got = %d", c.Pid())
+		}
+	}
+	if err := slow.Start(); !errors.Is(err, ErrAlreadyStarted) {
+		t.Errorf("Start() of a running command error = %v, want %v", err, ErrAlreadyStarted)
+	}
+
+	select {
+	case <-fast.Done():
+		if fast.Status.StdOut != "fast\n" {
+			t.Errorf("Status.StdOut got = %q", fast.Status.StdOut)
+		}
+	case <-slow.Done():
+		t.Fatalf("slow command exited first")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("fast command did not exit")
+	}
+
+	if err := slow.Signal(syscall.SIGTERM); err != nil {
+		t.Fatalf("Signal() error = %v", err)
+	}
+	if _, err := slow.Wait(); !errors.Is(err, ErrSignaled) || slow.Status.Signal != syscall.SIGTERM {
+		t.Errorf("Wait() error = %v, signal %v, want %v", err, slow.Status.Signal, syscall.SIGTERM)
+	}
+	if err := slow.Signal(syscall.SIGTERM); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Signal() after exit error = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err := fast.Run(); err != nil || fast.Status.StdOut != "fast\n" {
+		t.Errorf("Run() after Start() error = %v, stdout %q", err, fast.Status.StdOut)
+	}
+}
+
+// assertNotRunning checks that the process with the given pid has exited
+func assertNotRunning(t *testing.T, pid string) {
+	t.Helper()
+	p, err := strconv.Atoi(strings.TrimSpace(pid))
+	if err != nil {
+		t.Errorf("invalid pid %q", pid)
+		return
+	}
+	// the killed grandchild is reparented and stays a zombie until its new parent reaps it
+	deadline := time.Now().Add(2 * time.Second)
+	for syscall.Kill(p, 0) == nil && !isZombie(p) {
+		if time.Now().After(deadline) {
+			t.Errorf("process %d is still running", p)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func isZombie(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return false
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) > 0 && fields[0] == "Z"
+}
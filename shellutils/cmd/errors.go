@@ -0,0 +1,78 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var (
+	// ErrNotFound is returned when the command binary cannot be found
+	ErrNotFound = errors.New("command not found")
+	// ErrPermission is returned when the command binary cannot be executed
+	ErrPermission = errors.New("permission denied")
+	// ErrTimeout is returned when the command is killed because its context expired
+	ErrTimeout = errors.New("command timed out")
+	// ErrSignaled is returned when the command is terminated by a signal
+	ErrSignaled = errors.New("command terminated by a signal")
+)
+
+// startError classifies the error returned when the command cannot be started
+func startError(err error) error {
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case errors.Is(err, os.ErrPermission):
+		return fmt.Errorf("%w: %v", ErrPermission, err)
+	default:
+		return err
+	}
+}
+
+// waitError fills the exit code and terminating signal of the status and classifies the error returned by Wait.
+// ctxErr is the error of the context when it triggered the termination of the command, the command then
+// failed whatever its exit status, as it may trap the termination signal and exit 0.
+// A non-zero exit code alone is not an error, callers check Status.ExitCode
+func waitError(ctxErr error, err error, status *Status) error {
+	if err != nil {
+		exitError, ok := err.(*exec.ExitError)
+		if !ok {
+			return err
+		}
+		status.ExitCode = exitError.ExitCode()
+		if ws, ok := exitError.Sys().(interface {
+			Signaled() bool
+			Signal() syscall.Signal
+		}); ok && ws.Signaled() {
+			status.Signal = ws.Signal()
+		}
+	}
+	if ctxErr != nil {
+		status.ContextExpired = true
+		if errors.Is(ctxErr, context.DeadlineExceeded) {
+			return fmt.Errorf("%w: %v", ErrTimeout, ctxErr)
+		}
+		return fmt.Errorf("command canceled: %w", ctxErr)
+	}
+	if status.Signal != nil {
+		return fmt.Errorf("%w: %v", ErrSignaled, status.Signal)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Retention decides which part of a stream is kept in Status when the stream is longer than its capture limit
+type Retention int
+
+const (
+	// KeepTail keeps the last bytes of the stream
+	KeepTail Retention = iota
+	// KeepHead keeps the first bytes of the stream
+	KeepHead
+	// KeepHeadAndTail keeps the first and the last bytes of the stream, half of the limit each
+	KeepHeadAndTail
+)
+
+// output holds the sinks a command stream is copied to while the command runs
+type output struct {
+	writers  []io.Writer
+	lineFns  []func(line string)
+	maxBytes int // maxBytes captured in Status, everything if zero and nothing if negative
+	keep     Retention
+}
+
+func mergeOutputs(outputs ...output) output {
+	merged := output{}
+	if len(outputs) > 0 {
+		merged.maxBytes, merged.keep = outputs[0].maxBytes, outputs[0].keep
+	}
+	for _, o := range outputs {
+		merged.writers = append(merged.writers, o.writers...)
+		merged.lineFns = append(merged.lineFns, o.lineFns...)
+	}
+	return merged
+}
+
+// captureBuffer counts the bytes of a stream and keeps its output,
+// up to limit bytes following the retention when limit is positive and nothing when it is negative
+type captureBuffer struct {
+	limit int
+	keep  Retention
+	head  []byte
+	tail  []byte
+	total int64
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	written := len(p)
+	c.total += int64(written)
+	if c.limit < 0 {
+		return written, nil
+	}
+	if c.limit == 0 {
+		c.head = append(c.head, p...)
+		return written, nil
+	}
+	headLimit := 0
+	switch c.keep {
+	case KeepHead:
+		headLimit = c.limit
+	case KeepHeadAndTail:
+		headLimit = c.limit / 2
+	}
+	if room := headLimit - len(c.head); room > 0 {
+		if room > len(p) {
+			room = len(p)
+		}
+		c.head = append(c.head, p[:room]...)
+		p = p[room:]
+	}
+	if tailLimit := c.limit - headLimit; tailLimit > 0 && len(p) > 0 {
+		if len(p) > tailLimit {
+			p = p[len(p)-tailLimit:]
+		}
+		c.tail = append(c.tail, p...)
+		if len(c.tail) > tailLimit {
+			n := copy(c.tail, c.tail[len(c.tail)-tailLimit:])
+			c.tail = c.tail[:n]
+		}
+	}
+	return written, nil
+}
+
+// truncated returns the number of bytes of the stream that were not kept because of the limit
+func (c *captureBuffer) truncated() int64 {
+	if c.limit < 0 {
+		return 0
+	}
+	return c.total - int64(len(c.head)) - int64(len(c.tail))
+}
+
+// String returns the kept output, with a marker where bytes were left out
+func (c *captureBuffer) String() string {
+	if c.truncated() == 0 {
+		return string(c.head) + string(c.tail)
+	}
+	marker := fmt.Sprintf("[... %d bytes truncated ...]", c.truncated())
+	switch c.keep {
+	case KeepHead:
+		return string(c.head) + "\n" + marker
+	case KeepHeadAndTail:
+		return string(c.head) + "\n" + marker + "\n" + string(c.tail)
+	default:
+		return marker + "\n" + string(c.tail)
+	}
+}
+
+// lineWriter calls fn for every complete line written, without the line ending
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.buf = append(l.buf, p...)
+	for {
+		i := bytes.IndexByte(l.buf, '\n')
+		if i < 0 {
+			break
+		}
+		l.fn(string(bytes.TrimSuffix(l.buf[:i], []byte("\r"))))
+		l.buf = l.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush calls fn with the last line if the stream did not end with a line ending
+func (l *lineWriter) flush() {
+	if len(l.buf) > 0 {
+		l.fn(string(l.buf))
+		l.buf = nil
+	}
+}
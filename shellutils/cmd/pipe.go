@@ -0,0 +1,118 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrEmptyPipeline is returned when a pipeline without commands is run
+var ErrEmptyPipeline = errors.New("empty pipeline")
+
+// Pipeline is a chain of commands where the stdout of every command is the stdin of the next one,
+// like cmdA | cmdB | cmdC without a shell
+type Pipeline struct {
+	Commands []*Command
+	Statuses []Status // Statuses of every command, in order
+	ExitCode int      // ExitCode of the last command that exited with a non-zero code, 0 if they all succeeded
+}
+
+// Pipe returns a new pipeline of the commands.
+// The stdout of every command but the last one goes to the next command, so it is neither captured
+// nor copied to the stdout sinks, and the stdin of every command but the first one is ignored.
+// With WithCombinedOutput, stderr goes to the next command as well like |&
+func Pipe(commands ...*Command) *Pipeline {
+	return &Pipeline{
+		Commands: commands,
+	}
+}
+
+// Run starts every command of the pipeline and waits for all of them to exit.
+// Commands that cannot be started do not prevent the other ones from running, their
+// exit code is -1. The returned error is the first start error, else the first error
+// returned by a command, in order. Like with pipefail, a command killed by SIGPIPE
+// because the next one exited early is an error
+func (p *Pipeline) Run() (*Pipeline, error) {
+	p.Statuses = nil
+	p.ExitCode = 0
+	if len(p.Commands) == 0 {
+		return p, ErrEmptyPipeline
+	}
+
+	errs := make([]error, len(p.Commands))
+	started := make([]bool, len(p.Commands))
+	var stdin *os.File
+	for i, c := range p.Commands {
+		c.pipeIn = stdin
+		var stdout *os.File
+		if i < len(p.Commands)-1 {
+			r, w, err := os.Pipe()
+			if err != nil {
+				p.closeUnstarted(stdin, i, errs, err)
+				break
+			}
+			c.pipeOut = w
+			stdout = r
+		}
+		errs[i] = c.Start()
+		started[i] = errs[i] == nil
+		// the started process holds its own copies of the pipe ends
+		if c.pipeIn != nil {
+			_ = c.pipeIn.Close()
+		}
+		if c.pipeOut != nil {
+			_ = c.pipeOut.Close()
+		}
+		c.pipeIn, c.pipeOut = nil, nil
+		stdin = stdout
+	}
+
+	startErr := firstError(errs)
+	for i, c := range p.Commands {
+		if started[i] {
+			_, errs[i] = c.Wait()
+		}
+		p.Statuses = append(p.Statuses, c.Status)
+		if c.Status.ExitCode != 0 {
+			p.ExitCode = c.Status.ExitCode
+		}
+	}
+	if startErr != nil {
+		return p, startErr
+	}
+	return p, firstError(errs)
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeUnstarted records err for the commands from index i that cannot be started
+func (p *Pipeline) closeUnstarted(stdin *os.File, i int, errs []error, err error) {
+	if stdin != nil {
+		_ = stdin.Close()
+	}
+	for ; i < len(p.Commands); i++ {
+		p.Commands[i].pipeIn = nil
+		p.Commands[i].Status = Status{ExitCode: -1}
+		errs[i] = err
+	}
+}
@@ -0,0 +1,94 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPipe(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name         string
+		pipeline     *Pipeline
+		wantStdOut   string
+		wantExitCode int
+		wantErr      error
+	}{
+		{
+			name: "three stages",
+			pipeline: Pipe(
+				New(ctx, "printf", []string{"b\\na\\nc\\n"}),
+				New(ctx, "sort", nil),
+				New(ctx, "head", []string{"-n", "2"}),
+			),
+			wantStdOut: "a\nb\n",
+		},
+		{
+			name: "pipefail",
+			pipeline: Pipe(
+				New(ctx, "sh", []string{"-c", "echo x; exit 3"}),
+				New(ctx, "sh", []string{"-c", "cat; exit 0"}),
+			),
+			wantStdOut:   "x\n",
+			wantExitCode: 3,
+		},
+		{
+			name: "rightmost failure",
+			pipeline: Pipe(
+				New(ctx, "sh", []string{"-c", "exit 3"}),
+				New(ctx, "sh", []string{"-c", "cat; exit 4"}),
+			),
+			wantExitCode: 4,
+		},
+		{
+			name: "stage not found",
+			pipeline: Pipe(
+				New(ctx, "echo", []string{"x"}),
+				New(ctx, "/nonexistent/binary", nil),
+				New(ctx, "cat", nil),
+			),
+			wantExitCode: -1,
+			wantErr:      ErrNotFound,
+		},
+		{
+			name:     "empty",
+			pipeline: Pipe(),
+			wantErr:  ErrEmptyPipeline,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.pipeline.Run()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(p.Commands) == 0 {
+				return
+			}
+			if len(p.Statuses) != len(p.Commands) {
+				t.Errorf("Statuses got %d, want %d", len(p.Statuses), len(p.Commands))
+			}
+			if got := p.Statuses[len(p.Statuses)-1].StdOut; got != tt.wantStdOut {
+				t.Errorf("last stage stdout got = %q, want %q", got, tt.wantStdOut)
+			}
+			if p.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode got = %d, want %d", p.ExitCode, tt.wantExitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Quote returns s quoted for a POSIX shell so it is always read as a single word.
+// Words made only of characters without a special meaning are returned as they are
+func Quote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if isShellSafe(s) {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// WithExpressionf is like WithExpression with the expression formatted as fmt.Sprintf does,
+// except that every argument is quoted with Quote once formatted. The %q verb always quotes,
+// even words that do not need it.
+//
+//	c.WithExpressionf("bash", "grep %q %s | wc -l", pattern, file)
+func (c *Command) WithExpressionf(cmdBin string, format string, args ...interface{}) *Command {
+	quoted := make([]interface{}, 0, len(args))
+	for _, arg := range args {
+		quoted = append(quoted, shellArg{arg})
+	}
+	return c.WithExpression(cmdBin, fmt.Sprintf(format, quoted...))
+}
+
+// shellArg formats its value and quotes the result for a POSIX shell
+type shellArg struct {
+	value interface{}
+}
+
+func (a shellArg) Format(f fmt.State, verb rune) {
+	if verb == 'q' {
+		s := fmt.Sprint(a.value)
+		_, _ = fmt.Fprint(f, "'"+strings.ReplaceAll(s, "'", `'\''`)+"'")
+		return
+	}
+	_, _ = fmt.Fprint(f, Quote(fmt.Sprintf(formatDirective(f, verb), a.value)))
+}
+
+// formatDirective rebuilds the directive, flags, width and precision included, being formatted
+func formatDirective(f fmt.State, verb rune) string {
+	directive := "%"
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			directive += string(flag)
+		}
+	}
+	if width, ok := f.Width(); ok {
+		directive += strconv.Itoa(width)
+	}
+	if precision, ok := f.Precision(); ok {
+		directive += "." + strconv.Itoa(precision)
+	}
+	return directive + string(verb)
+}
+
+func isShellSafe(s string) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case strings.ContainsRune("_@%+=:,./-", r):
+		default:
+			return false
+		}
+	}
+	return true
+}
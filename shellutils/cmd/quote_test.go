@@ -0,0 +1,72 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		want string
+	}{
+		{name: "empty", arg: "", want: "''"},
+		{name: "safe", arg: "hadoop-hdfs-namenode.service", want: "hadoop-hdfs-namenode.service"},
+		{name: "spaces", arg: "a b", want: "'a b'"},
+		{name: "single quote", arg: "it's", want: `'it'\''s'`},
+		{name: "command substitution", arg: "$(rm -rf /)", want: "'$(rm -rf /)'"},
+		{name: "separator", arg: "x; reboot", want: "'x; reboot'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Quote(tt.arg); got != tt.want {
+				t.Errorf("Quote() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithExpressionf(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		args   []interface{}
+		want   string
+	}{
+		{name: "plain", format: "echo %s %q", args: []interface{}{"unit", "unit"}, want: "echo unit 'unit'"},
+		{name: "number", format: "exit %03d", args: []interface{}{7}, want: "exit 007"},
+		{name: "injection", format: "echo %s", args: []interface{}{"x'; echo pwned; '"}, want: `echo 'x'\''; echo pwned; '\'''`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(context.Background(), "", nil).WithExpressionf("sh", tt.format, tt.args...)
+			if c.Command != "sh" || len(c.Args) != 2 || c.Args[1] != tt.want {
+				t.Errorf("WithExpressionf() got = %v %q, want %q", c.Command, c.Args, tt.want)
+			}
+		})
+	}
+
+	unsafe := "$(echo injected) 'quoted' `ticks` \\ \"double\""
+	c := New(context.Background(), "", nil).WithExpressionf("sh", "printf '%%s' %s", unsafe)
+	if _, err := c.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if c.Status.StdOut != unsafe {
+		t.Errorf("Status.StdOut got = %q, want %q", c.Status.StdOut, unsafe)
+	}
+}
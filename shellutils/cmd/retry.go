@@ -0,0 +1,128 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMultiplier     = 2
+)
+
+// RetryPolicy sets when and how often Run reruns a command that failed
+type RetryPolicy struct {
+	MaxAttempts    int              // MaxAttempts including the first run, the command runs once if less than 2
+	InitialBackoff time.Duration    // InitialBackoff before the second attempt, 100ms by default
+	MaxBackoff     time.Duration    // MaxBackoff caps the backoff between attempts, no cap if zero
+	Multiplier     float64          // Multiplier of the backoff after every attempt, 2 by default
+	Jitter         float64          // Jitter randomizes every backoff by up to this fraction of it, between 0 and 1
+	ExitCodes      []int            // ExitCodes that are retried, every non-zero exit code when no condition is set
+	StderrPatterns []*regexp.Regexp // StderrPatterns retried when they match the stderr, or combined output, of an attempt
+}
+
+// WithRetry makes Run rerun the command following the retry policy, every attempt is recorded in History.
+// The output sinks get the output of every attempt and a stdin reader is only read by the first one.
+// Start and Wait run the command once
+func (c *Command) WithRetry(policy RetryPolicy) *Command {
+	c.retry = &policy
+	return c
+}
+
+// runWithRetry runs the command until it succeeds, a condition of the retry policy no longer matches,
+// the attempts are exhausted or the context is done
+func (c *Command) runWithRetry() (*Command, error) {
+	c.History = nil
+	backoff := c.retry.InitialBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryInitialBackoff
+	}
+	for attempt := 1; ; attempt++ {
+		if err := c.Start(); err != nil {
+			c.History = append(c.History, c.Status)
+			return c, err
+		}
+		_, err := c.Wait()
+		c.History = append(c.History, c.Status)
+		if err != nil || attempt >= c.retry.MaxAttempts || !c.retry.matches(&c.Status) {
+			return c, err
+		}
+
+		timer := time.NewTimer(c.retry.jitter(backoff))
+		select {
+		case <-c.Ctx.Done():
+			timer.Stop()
+			if errors.Is(c.Ctx.Err(), context.DeadlineExceeded) {
+				return c, fmt.Errorf("%w: %v", ErrTimeout, c.Ctx.Err())
+			}
+			return c, fmt.Errorf("command canceled: %w", c.Ctx.Err())
+		case <-timer.C:
+		}
+		backoff = c.retry.next(backoff)
+	}
+}
+
+// matches returns true if the status of an attempt meets a condition of the retry policy
+func (p *RetryPolicy) matches(status *Status) bool {
+	if len(p.ExitCodes) == 0 && len(p.StderrPatterns) == 0 {
+		return status.ExitCode != 0
+	}
+	for _, code := range p.ExitCodes {
+		if status.ExitCode == code {
+			return true
+		}
+	}
+	stderr := status.StdErr
+	if stderr == "" {
+		stderr = status.Combined
+	}
+	for _, pattern := range p.StderrPatterns {
+		if pattern.MatchString(stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+// next returns the backoff following the given one
+func (p *RetryPolicy) next(backoff time.Duration) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	backoff = time.Duration(float64(backoff) * multiplier)
+	if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+		backoff = p.MaxBackoff
+	}
+	return backoff
+}
+
+// jitter randomizes the backoff by up to the jitter fraction in both directions
+func (p *RetryPolicy) jitter(backoff time.Duration) time.Duration {
+	jitter := p.Jitter
+	if jitter <= 0 {
+		return backoff
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	return time.Duration(float64(backoff) * (1 + jitter*(2*rand.Float64()-1)))
+}
@@ -0,0 +1,133 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCommandRetry(t *testing.T) {
+	script := `n=$(cat "$1" 2>/dev/null || echo 0); n=$((n+1)); echo $n > "$1"; ` +
+		`[ $n -ge "$2" ] && exit 0; printf '%s' "$4" >&2; exit "$3"`
+	tests := []struct {
+		name         string
+		succeedAt    int
+		exitCode     string
+		stderr       string
+		policy       RetryPolicy
+		wantAttempts int
+		wantExitCode int
+	}{
+		{
+			name:         "any failure until success",
+			succeedAt:    3,
+			exitCode:     "1",
+			policy:       RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond},
+			wantAttempts: 3,
+		},
+		{
+			name:         "attempts exhausted",
+			succeedAt:    10,
+			exitCode:     "1",
+			policy:       RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+			wantAttempts: 2,
+			wantExitCode: 1,
+		},
+		{
+			name:         "exit code not retried",
+			succeedAt:    3,
+			exitCode:     "2",
+			policy:       RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, ExitCodes: []int{75}},
+			wantAttempts: 1,
+			wantExitCode: 2,
+		},
+		{
+			name:         "exit code retried",
+			succeedAt:    3,
+			exitCode:     "75",
+			policy:       RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, ExitCodes: []int{75}},
+			wantAttempts: 3,
+		},
+		{
+			name:      "stderr pattern retried",
+			succeedAt: 2,
+			exitCode:  "1",
+			stderr:    "connection refused",
+			policy: RetryPolicy{MaxAttempts: 5, InitialBackoff: time.Millisecond, Jitter: 0.5,
+				StderrPatterns: []*regexp.Regexp{regexp.MustCompile(`connection (refused|reset)`)}},
+			wantAttempts: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := filepath.Join(t.TempDir(), "attempts")
+			c := New(context.Background(), "sh", []string{"-c", script, "sh", counter, strconv.Itoa(tt.succeedAt), tt.exitCode, tt.stderr}).
+				WithRetry(tt.policy)
+			if _, err := c.Run(); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if len(c.History) != tt.wantAttempts {
+				t.Errorf("History got %d attempts, want %d", len(c.History), tt.wantAttempts)
+			}
+			if c.Status.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode got = %d, want %d", c.Status.ExitCode, tt.wantExitCode)
+			}
+			for i, status := range c.History[:len(c.History)-1] {
+				if status.ExitCode == 0 || status.StdErr != tt.stderr {
+					t.Errorf("History[%d] got = %d %q, want a failed attempt", i, status.ExitCode, status.StdErr)
+				}
+			}
+		})
+	}
+}
+
+func TestCommandRetryContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	c := New(ctx, "false", nil).WithRetry(RetryPolicy{MaxAttempts: 10, InitialBackoff: time.Hour})
+	_, err := c.Run()
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("Run() error = %v, want ErrTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %v, the backoff should stop with the context", elapsed)
+	}
+	if len(c.History) != 1 {
+		t.Errorf("History got %d attempts, want 1", len(c.History))
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{MaxBackoff: 300 * time.Millisecond}
+	backoff := 100 * time.Millisecond
+	for _, want := range []time.Duration{200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		if backoff = p.next(backoff); backoff != want {
+			t.Errorf("next() got = %v, want %v", backoff, want)
+		}
+	}
+	p.Jitter = 0.2
+	for i := 0; i < 100; i++ {
+		if got := p.jitter(time.Second); got < 800*time.Millisecond || got > 1200*time.Millisecond {
+			t.Fatalf("jitter() got = %v, want within 20%% of 1s", got)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+// Acceldata Inc. and its affiliates.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// 	Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+var (
+	// ErrNotRunning is returned when the command was not started or has already exited
+	ErrNotRunning = errors.New("command is not running")
+	// ErrAlreadyStarted is returned by Start when the command is still running
+	ErrAlreadyStarted = errors.New("command is already running")
+)
+
+// TerminationPolicy sets how a command and its process group are terminated
+// when the context of the command is done or Kill is called
+type TerminationPolicy struct {
+	Signal      os.Signal     // Signal sent first to the process group, os.Kill by default
+	GracePeriod time.Duration // GracePeriod before os.Kill is sent to the process group if it is still running, none if zero
+}
+
+// WithTermination sets the termination policy of the command.
+// By default the process group is killed right away, like exec.CommandContext does for the process alone
+func (c *Command) WithTermination(signal os.Signal, gracePeriod time.Duration) *Command {
+	c.termination = TerminationPolicy{Signal: signal, GracePeriod: gracePeriod}
+	return c
+}
+
+// Kill terminates the running command and its process group following the termination policy.
+// Kill does not wait for the command to exit
+func (c *Command) Kill() error {
+	run := c.execution()
+	if run == nil {
+		return ErrNotRunning
+	}
+	return c.terminate(run)
+}
+
+func (c *Command) terminate(run *execution) error {
+	select {
+	case <-run.done:
+		return ErrNotRunning
+	default:
+	}
+	sig := c.termination.Signal
+	if sig == nil {
+		sig = os.Kill
+	}
+	if err := signalGroup(run.cmd.Process, sig); err != nil {
+		return err
+	}
+	if sig == os.Kill || c.termination.GracePeriod <= 0 {
+		return nil
+	}
+	go func() {
+		timer := time.NewTimer(c.termination.GracePeriod)
+		defer timer.Stop()
+		select {
+		case <-run.done:
+		case <-timer.C:
+			_ = signalGroup(run.cmd.Process, os.Kill)
+		}
+	}()
+	return nil
+}